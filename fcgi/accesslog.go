@@ -0,0 +1,282 @@
+package main
+
+// Access logging, one line per request once it is served, in the Common Log
+// Format, nginx's combined format, or JSON. The first two end with the
+// request ID, like nginx's $request_id:
+//
+//	127.0.0.1 - - [19/Oct/2026:13:50:11 +0000] "GET /x HTTP/1.1" 200 512 "-" "curl/7.88.1" "0f277b0d1e1f45f747d07d8ac2f19788"
+//	{"time":"...","request_id":"...","status":200,"bytes":512,"duration_ms":0.42,...}
+//
+// The log goes to standard error, or to -access-log. That file is rotated to
+// FILE.1, FILE.2... when it reaches -access-log-max-size, and reopened on
+// SIGHUP for logrotate.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var accessLog *accessLogger
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// withAccessLog logs every request to accessLog once h is done with it.
+func withAccessLog(h http.Handler) http.Handler {
+	if accessLog == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		accessLog.log(r, rec.status, rec.bytes, start, time.Since(start))
+	})
+}
+
+type accessLogger struct {
+	format string // "clf", "combined" or "json"
+
+	mu       sync.Mutex
+	path     string // "" for standard error
+	w        io.Writer
+	file     *os.File
+	size     int64
+	maxSize  int64 // rotate when the file would grow past this; 0 never
+	maxFiles int   // rotated files kept
+}
+
+func newAccessLogger(format, path string, maxSize int64, maxFiles int) (*accessLogger, error) {
+	switch format {
+	case "clf", "combined", "json":
+	default:
+		return nil, fmt.Errorf("unknown access log format %q, want clf, combined or json", format)
+	}
+	l := &accessLogger{format: format, path: path, w: os.Stderr, maxSize: maxSize, maxFiles: maxFiles}
+	if path != "" {
+		if err := l.open(); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *accessLogger) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.w, l.size = f, f, fi.Size()
+	return nil
+}
+
+// reopen closes and opens the file again, after logrotate moved it away.
+// If the file cannot be opened, the log goes to standard error until the
+// next reopen.
+func (l *accessLogger) reopen() error {
+	if l == nil || l.path == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	if err := l.open(); err != nil {
+		l.file, l.w = nil, os.Stderr
+		return err
+	}
+	return nil
+}
+
+// rotate renames FILE.n to FILE.n+1, dropping the oldest, and FILE to
+// FILE.1, then starts a new FILE.
+func (l *accessLogger) rotate() error {
+	l.file.Close()
+	os.Remove(l.path + "." + strconv.Itoa(l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(l.path+"."+strconv.Itoa(i), l.path+"."+strconv.Itoa(i+1))
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *accessLogger) write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			log.Printf("access log: %v", err)
+			l.file, l.w = nil, os.Stderr
+		}
+	}
+	n, _ := l.w.Write(line)
+	l.size += int64(n)
+}
+
+func (l *accessLogger) close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.file.Close()
+	l.file, l.w = nil, os.Stderr
+	return err
+}
+
+// reopenOnSIGHUP reopens the log file whenever the process gets SIGHUP.
+func (l *accessLogger) reopenOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := l.reopen(); err != nil {
+				log.Printf("access log: %v", err)
+			}
+		}
+	}()
+}
+
+type accessEntry struct {
+	Time       string            `json:"time"`
+	RequestID  string            `json:"request_id"`
+	RemoteAddr string            `json:"remote_addr"`
+	User       string            `json:"user,omitempty"`
+	Method     string            `json:"method"`
+	URI        string            `json:"uri"`
+	Proto      string            `json:"proto"`
+	Host       string            `json:"host"`
+	Status     int               `json:"status"`
+	Bytes      int64             `json:"bytes"`
+	DurationMS float64           `json:"duration_ms"`
+	Referer    string            `json:"referer,omitempty"`
+	UserAgent  string            `json:"user_agent,omitempty"`
+	Gateway    map[string]string `json:"gateway,omitempty"` // see gatewayEnv
+}
+
+func (l *accessLogger) log(r *http.Request, status int, bytes int64, start time.Time, d time.Duration) {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = "-"
+	}
+	user, _, _ := r.BasicAuth()
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	if l.format == "json" {
+		line, err := json.Marshal(accessEntry{
+			Time:       start.Format(time.RFC3339Nano),
+			RequestID:  requestID(r),
+			RemoteAddr: host,
+			User:       user,
+			Method:     r.Method,
+			URI:        uri,
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Status:     status,
+			Bytes:      bytes,
+			DurationMS: float64(d) / float64(time.Millisecond),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			Gateway:    gatewayEnv(r),
+		})
+		if err != nil {
+			log.Printf("access log: %v", err)
+			return
+		}
+		l.write(append(line, '\n'))
+		return
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		host, orDash(escapeLogField(user)), start.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(r.Method+" "+uri+" "+r.Proto), status, size)
+	if l.format == "combined" {
+		line += " " + strconv.Quote(orDash(r.Referer())) + " " + strconv.Quote(orDash(r.UserAgent()))
+	}
+	line += " " + strconv.Quote(orDash(requestID(r)))
+	l.write([]byte(line + "\n"))
+}
+
+// escapeLogField writes the bytes of s that could end or forge a field of a
+// log line, such as spaces, quotes and newlines, as \xNN, as nginx does.
+func escapeLogField(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c == '"' || c == '\\' || c >= 0x7f {
+			fmt.Fprintf(&b, "\\x%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
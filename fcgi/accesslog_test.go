@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestEscapeLogField(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"alice", "alice"},
+		{"a b", `a\x20b`},
+		{`x" 200 "evil`, `x\x22\x20200\x20\x22evil`},
+		{"line\nbreak\r\t", `line\x0Abreak\x0D\x09`},
+		{`back\slash`, `back\x5Cslash`},
+		{"\x00\x7f", `\x00\x7F`},
+		{"é", `\xC3\xA9`},
+	}
+	for _, tt := range tests {
+		if got := escapeLogField(tt.in); got != tt.want {
+			t.Errorf("escapeLogField(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAccessLogRotateKeepsMaxFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcgi-accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "access.log")
+	l, err := newAccessLogger("clf", path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	line := strings.Repeat("x", 39) + "\n"
+	for i := 0; i < 20; i++ {
+		l.write([]byte(line))
+	}
+
+	names, _ := filepath.Glob(path + "*")
+	sort.Strings(names)
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	if got := strings.Join(names, " "); got != "access.log access.log.1 access.log.2" {
+		t.Fatalf("files %q, want the log and 2 rotated ones", got)
+	}
+	for _, name := range []string{"access.log.1", "access.log.2"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != 2*len(line) {
+			t.Errorf("%s has %d bytes, want the 2 lines that fit in 100", name, len(b))
+		}
+	}
+}
+
+func TestAccessLogReopenFallsBackToStderr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcgi-accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newAccessLogger("clf", filepath.Join(dir, "logs", "access.log"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	os.RemoveAll(filepath.Join(dir, "logs"))
+	if err := l.reopen(); err == nil {
+		t.Fatal("reopened a log in a removed directory")
+	}
+	if l.file != nil || l.w != os.Stderr {
+		t.Fatalf("after a failed reopen: file %v, want the log on standard error", l.file)
+	}
+
+	os.Mkdir(filepath.Join(dir, "logs"), 0755)
+	if err := l.reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.write([]byte("back\n"))
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "logs", "access.log")); string(b) != "back\n" {
+		t.Errorf("after reopening again: log has %q, want the new line", b)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+// Diagnostic endpoints in the style of httpbin.org, for testing HTTP clients
+// and proxies:
+//
+//	/status/{code}             respond with that status
+//	/delay/{seconds}           respond after a delay, at most 10s
+//	/redirect/{n}              redirect n times, then to /anything
+//	/bytes/{n}?seed=S          n random bytes, the same ones for the same seed
+//	/stream/{n}                n JSON lines, flushed one by one
+//	/cookies                   the cookies sent
+//	/cookies/set?name=value    set cookies and redirect to /cookies
+//	/basic-auth/{user}/{pass}  200 if the request has these credentials, else 401
+//	/gzip                      the request as gzip-compressed JSON
+//	/headers                   the request headers
+//	/ip                        the client address
+//	/anything                  the request as JSON, for any method and path below it
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxDelay  = 10 * time.Second
+	maxBytes  = 100 << 10
+	maxStream = 100
+)
+
+func handleBin(mux *http.ServeMux) {
+	mux.HandleFunc("/status/", binStatus)
+	mux.HandleFunc("/delay/", binDelay)
+	mux.HandleFunc("/redirect/", binRedirect)
+	mux.HandleFunc("/bytes/", binBytes)
+	mux.HandleFunc("/stream/", binStream)
+	mux.HandleFunc("/cookies", binCookies)
+	mux.HandleFunc("/cookies/set", binSetCookies)
+	mux.HandleFunc("/basic-auth/", binBasicAuth)
+	mux.HandleFunc("/gzip", binGzip)
+	mux.HandleFunc("/headers", binHeaders)
+	mux.HandleFunc("/ip", binIP)
+	mux.HandleFunc("/anything", binAnything)
+	mux.HandleFunc("/anything/", binAnything)
+}
+
+// pathInt parses the path element after prefix as an integer in [min, max].
+func pathInt(r *http.Request, prefix string, min, max int) (int, error) {
+	s := strings.TrimPrefix(r.URL.Path, prefix)
+	n, err := strconv.Atoi(s)
+	if err != nil || n < min || n > max {
+		return 0, fmt.Errorf("%q is not a number from %d to %d", s, min, max)
+	}
+	return n, nil
+}
+
+// requestEcho describes r as the echo page does.
+func requestEcho(r *http.Request) *echo {
+	body, _ := ioutil.ReadAll(r.Body)
+	return newEcho(r, body, requestIndex(r))
+}
+
+func binStatus(w http.ResponseWriter, r *http.Request) {
+	code, err := pathInt(r, "/status/", 100, 599)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if code >= 300 && code < 400 {
+		w.Header().Set("Location", "/redirect/1")
+	}
+	w.WriteHeader(code)
+}
+
+func binDelay(w http.ResponseWriter, r *http.Request) {
+	s := strings.TrimPrefix(r.URL.Path, "/delay/")
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs < 0 {
+		http.Error(w, fmt.Sprintf("%q is not a number of seconds", s), http.StatusBadRequest)
+		return
+	}
+	d := time.Duration(secs * float64(time.Second))
+	if d > maxDelay {
+		d = maxDelay
+	}
+	select {
+	case <-time.After(d):
+	case <-r.Context().Done():
+		return
+	}
+	writeJSON(w, requestEcho(r))
+}
+
+func binRedirect(w http.ResponseWriter, r *http.Request) {
+	n, err := pathInt(r, "/redirect/", 1, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	next := "/anything"
+	if n > 1 {
+		next = "/redirect/" + strconv.Itoa(n-1)
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+func binBytes(w http.ResponseWriter, r *http.Request) {
+	n, err := pathInt(r, "/bytes/", 0, maxBytes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	seed := time.Now().UnixNano()
+	if s := r.URL.Query().Get("seed"); s != "" {
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("seed %q is not an integer", s), http.StatusBadRequest)
+			return
+		}
+	}
+	b := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(b)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.Write(b)
+}
+
+func binStream(w http.ResponseWriter, r *http.Request) {
+	n, err := pathInt(r, "/stream/", 1, maxStream)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e := requestEcho(r)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		line := struct {
+			ID int `json:"id"`
+			*echo
+		}{i, e}
+		if err := enc.Encode(line); err != nil {
+			return
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+func binCookies(w http.ResponseWriter, r *http.Request) {
+	cookies := make(map[string]string)
+	for _, c := range r.Cookies() {
+		cookies[c.Name] = c.Value
+	}
+	writeJSON(w, map[string]interface{}{"cookies": cookies})
+}
+
+func binSetCookies(w http.ResponseWriter, r *http.Request) {
+	for name, values := range r.URL.Query() {
+		http.SetCookie(w, &http.Cookie{Name: name, Value: values[0], Path: "/"})
+	}
+	http.Redirect(w, r, "/cookies", http.StatusFound)
+}
+
+func binBasicAuth(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/basic-auth/"), "/", 2)
+	if len(parts) != 2 {
+		http.Error(w, "want /basic-auth/{user}/{pass}", http.StatusBadRequest)
+		return
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != parts[0] || pass != parts[1] {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fcgi"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"authenticated": true, "user": user})
+}
+
+func binGzip(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Encoding", "gzip")
+	zw := gzip.NewWriter(w)
+	defer zw.Close()
+	enc := json.NewEncoder(zw)
+	enc.SetIndent("", "  ")
+	enc.Encode(struct {
+		Gzipped bool `json:"gzipped"`
+		*echo
+	}{true, requestEcho(r)})
+}
+
+func binHeaders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{"headers": r.Header})
+}
+
+func binIP(w http.ResponseWriter, r *http.Request) {
+	origin := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(origin); err == nil {
+		origin = host
+	}
+	writeJSON(w, map[string]string{"origin": origin})
+}
+
+func binAnything(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, requestEcho(r))
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// captured is one request as kept in memory and written to the capture file,
+// one JSON object per line.
+type captured struct {
+	Time         time.Time           `json:"time"`
+	ID           string              `json:"id"`
+	Index        int64               `json:"index"`
+	Method       string              `json:"method"`
+	URL          string              `json:"url"` // the request URI, e.g. /path?a=1
+	Proto        string              `json:"proto"`
+	Host         string              `json:"host"`
+	RemoteAddr   string              `json:"remote_addr"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding"` // "text", or "base64" if the body is not UTF-8
+}
+
+func newCaptured(r *http.Request, body []byte, index int64) *captured {
+	c := &captured{
+		Time:       time.Now().UTC(),
+		ID:         requestID(r),
+		Index:      index,
+		Method:     r.Method,
+		URL:        r.RequestURI,
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header,
+	}
+	if c.URL == "" {
+		c.URL = r.URL.RequestURI()
+	}
+	c.Body, c.BodyEncoding = encodeBody(body)
+	return c
+}
+
+type requestIndexKey struct{}
+
+// withCapture numbers every request and records it in captures before
+// handing it to h, with the body still there to read.
+func withCapture(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("request body larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		i := atomic.AddInt64(&index, 1) - 1
+		if err := captures.add(newCaptured(r, body, i)); err != nil {
+			log.Print(err)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIndexKey{}, i)))
+	})
+}
+
+// requestIndex returns the number given to r by withCapture.
+func requestIndex(r *http.Request) int64 {
+	i, _ := r.Context().Value(requestIndexKey{}).(int64)
+	return i
+}
+
+// body returns the request body as it was received.
+func (c *captured) body() ([]byte, error) {
+	return decodeBody(c.Body, c.BodyEncoding)
+}
+
+// captureStore keeps the last requests in a ring, and appends every request
+// to a file if it has one. A nil store records nothing.
+type captureStore struct {
+	mu       sync.Mutex
+	ring     []*captured // nil where a request was dropped for its size
+	next     int         // where the next request goes in ring
+	full     bool
+	bytes    int64 // of the bodies in ring
+	maxBytes int64 // 0 for no limit
+
+	file *os.File
+	w    *bufio.Writer
+
+	subs map[chan *captured]bool // live listeners, see subscribe
+}
+
+// newCaptureStore keeps up to size requests in memory, with bodies of up to
+// maxBytes in all, and appends them to the file at path, unless path is "".
+// The oldest requests go first to make room; the newest is always kept. A
+// maxBytes of 0 means no limit.
+func newCaptureStore(size int, maxBytes int64, path string) (*captureStore, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("capture size %d is negative", size)
+	}
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("capture bytes %d is negative", maxBytes)
+	}
+	s := &captureStore{ring: make([]*captured, size), maxBytes: maxBytes, subs: make(map[chan *captured]bool)}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		s.file, s.w = f, bufio.NewWriter(f)
+	}
+	return s, nil
+}
+
+// add records c. Each line is flushed so that the file is complete even if
+// the server is killed.
+func (s *captureStore) add(c *captured) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ring) > 0 {
+		if old := s.ring[s.next]; old != nil {
+			s.bytes -= int64(len(old.Body))
+		}
+		s.ring[s.next] = c
+		s.bytes += int64(len(c.Body))
+		s.next = (s.next + 1) % len(s.ring)
+		s.full = s.full || s.next == 0
+		s.shrink()
+	}
+	for ch := range s.subs {
+		select {
+		case ch <- c:
+		default: // a slow listener misses requests rather than blocking the server
+		}
+	}
+	if s.w == nil {
+		return nil
+	}
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	s.w.Write(append(line, '\n'))
+	return s.w.Flush()
+}
+
+// shrink drops the oldest requests but the newest until the bodies in the
+// ring fit in maxBytes.
+func (s *captureStore) shrink() {
+	oldest := 0
+	if s.full {
+		oldest = s.next
+	}
+	newest := (s.next - 1 + len(s.ring)) % len(s.ring)
+	for i := oldest; s.maxBytes > 0 && s.bytes > s.maxBytes && i != newest; i = (i + 1) % len(s.ring) {
+		if c := s.ring[i]; c != nil {
+			s.bytes -= int64(len(c.Body))
+			s.ring[i] = nil
+		}
+	}
+}
+
+// all returns the requests in the ring, oldest first.
+func (s *captureStore) all() []*captured {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ring := s.ring[:s.next]
+	if s.full {
+		ring = append(append([]*captured(nil), s.ring[s.next:]...), ring...)
+	}
+	var all []*captured
+	for _, c := range ring {
+		if c != nil {
+			all = append(all, c)
+		}
+	}
+	return all
+}
+
+// find returns the request in the ring with the given ID, or nil.
+func (s *captureStore) find(id string) *captured {
+	all := s.all()
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].ID == id {
+			return all[i]
+		}
+	}
+	return nil
+}
+
+// clear empties the ring. The capture file is left alone.
+func (s *captureStore) clear() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.ring {
+		s.ring[i] = nil
+	}
+	s.next, s.full, s.bytes = 0, false, 0
+}
+
+// subscribe returns a channel receiving every request added from now on,
+// and a function to stop receiving them.
+func (s *captureStore) subscribe() (<-chan *captured, func()) {
+	ch := make(chan *captured, 64)
+	s.mu.Lock()
+	s.subs[ch] = true
+	s.mu.Unlock()
+	return ch, func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}
+}
+
+// close flushes and closes the capture file.
+func (s *captureStore) close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.w.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file, s.w = nil, nil
+	return err
+}
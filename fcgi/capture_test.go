@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCaptureStoreByteBudget(t *testing.T) {
+	s, err := newCaptureStore(10, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(id string, size int) {
+		s.add(&captured{ID: id, Body: strings.Repeat("x", size)})
+	}
+	ids := func() string {
+		var ids []string
+		for _, c := range s.all() {
+			ids = append(ids, c.ID)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	for i := 1; i <= 4; i++ {
+		add(strconv.Itoa(i), 30)
+	}
+	if got := ids(); got != "2 3 4" {
+		t.Errorf("after 4 bodies of 30 bytes in 100: %q, want \"2 3 4\"", got)
+	}
+	add("big", 500)
+	if got := ids(); got != "big" {
+		t.Errorf("after a body larger than the budget: %q, want the newest only", got)
+	}
+	for i := 5; i <= 20; i++ {
+		add(strconv.Itoa(i), 0)
+	}
+	if got := ids(); got != "11 12 13 14 15 16 17 18 19 20" {
+		t.Errorf("after 16 empty bodies: %q, want the last 10", got)
+	}
+	if s.bytes != 0 {
+		t.Errorf("%d bytes counted for empty bodies", s.bytes)
+	}
+}
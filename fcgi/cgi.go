@@ -0,0 +1,49 @@
+package main
+
+// CGI mode: a web server that runs the program as a CGI script sets
+// GATEWAY_INTERFACE, and the program serves that one request with
+// net/http/cgi and exits.
+
+import (
+	"net/http"
+	"net/http/fcgi"
+	"os"
+)
+
+var cgiMode bool
+
+func init() {
+	cgiMode = os.Getenv("GATEWAY_INTERFACE") != ""
+}
+
+// cgiVariables are the meta-variables of RFC 3875, and a few that web
+// servers commonly add. HTTP_* variables are left out: they are the headers.
+var cgiVariables = []string{
+	"AUTH_TYPE", "CONTENT_LENGTH", "CONTENT_TYPE", "GATEWAY_INTERFACE",
+	"PATH_INFO", "PATH_TRANSLATED", "QUERY_STRING", "REMOTE_ADDR",
+	"REMOTE_HOST", "REMOTE_IDENT", "REMOTE_USER", "REQUEST_METHOD",
+	"SCRIPT_NAME", "SERVER_NAME", "SERVER_PORT", "SERVER_PROTOCOL",
+	"SERVER_SOFTWARE",
+	"CONTEXT_DOCUMENT_ROOT", "CONTEXT_PREFIX", "DOCUMENT_ROOT", "HTTPS",
+	"REDIRECT_STATUS", "REMOTE_PORT", "REQUEST_SCHEME", "REQUEST_URI",
+	"SCRIPT_FILENAME", "SERVER_ADDR",
+}
+
+// gatewayEnv returns what the web server passed along with r: the CGI
+// variables in CGI mode, or for a FastCGI request the parameters that did
+// not make it into r. It is nil for plain HTTP.
+func gatewayEnv(r *http.Request) map[string]string {
+	if cgiMode {
+		env := make(map[string]string)
+		for _, name := range cgiVariables {
+			if v, ok := os.LookupEnv(name); ok {
+				env[name] = v
+			}
+		}
+		return env
+	}
+	if env := fcgi.ProcessEnv(r); len(env) > 0 {
+		return env
+	}
+	return nil
+}
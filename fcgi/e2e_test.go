@@ -0,0 +1,348 @@
+package main
+
+// The end-to-end tests run this server as a FastCGI application, spawned
+// with a socket on standard input and listening on a Unix socket, and as a
+// CGI script, and check its answers through the FastCGI client or the CGI
+// environment.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yulvil/golang-examples/fcgi/fcgiclient"
+)
+
+type e2eCheck struct {
+	name   string
+	method string
+	path   string
+	header http.Header
+	body   []byte
+	check  func(resp *http.Response, body []byte) error
+}
+
+var e2eChecks = []e2eCheck{
+	{
+		name: "echo as JSON with the client's request ID", method: "GET", path: "/hello?format=json&a=1",
+		header: http.Header{"X-Request-Id": {"e2e-1"}},
+		check: func(resp *http.Response, body []byte) error {
+			var e echo
+			if err := json.Unmarshal(body, &e); err != nil {
+				return err
+			}
+			switch {
+			case resp.Header.Get("X-Request-ID") != "e2e-1":
+				return fmt.Errorf("X-Request-ID header %q", resp.Header.Get("X-Request-ID"))
+			case e.RequestID != "e2e-1":
+				return fmt.Errorf("request_id %q", e.RequestID)
+			case e.URL.Path != "/hello" || e.Query["a"][0] != "1":
+				return fmt.Errorf("url %+v, query %v", e.URL, e.Query)
+			}
+			return nil
+		},
+	},
+	{
+		name: "echo as HTML by default", method: "GET", path: "/",
+		check: func(resp *http.Response, body []byte) error {
+			if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !bytes.Contains(body, []byte("Hello world from Go!")) {
+				return fmt.Errorf("Content-Type %q, body %.60q", resp.Header.Get("Content-Type"), body)
+			}
+			return nil
+		},
+	},
+	{
+		name: "gateway environment", method: "GET", path: "/?format=json",
+		check: func(resp *http.Response, body []byte) error {
+			var e echo
+			if err := json.Unmarshal(body, &e); err != nil {
+				return err
+			}
+			if e.Env["SERVER_SOFTWARE"] != "fcgi-request" {
+				return fmt.Errorf("env %v has no SERVER_SOFTWARE=fcgi-request", e.Env)
+			}
+			return nil
+		},
+	},
+	{
+		name: "unknown format", method: "GET", path: "/?format=xml",
+		check: wantStatus(http.StatusBadRequest),
+	},
+	{
+		name: "POST body", method: "POST", path: "/anything",
+		header: http.Header{"Content-Type": {"text/plain"}},
+		body:   []byte("hello"),
+		check:  wantBody("hello", "text"),
+	},
+	{
+		name: "binary body", method: "PUT", path: "/anything",
+		body:  []byte{0xff, 0, 1},
+		check: wantBody("/wAB", "base64"),
+	},
+	{
+		name: "body larger than a record", method: "POST", path: "/anything",
+		body:  bytes.Repeat([]byte("0123456789"), 20000),
+		check: wantBody(strings.Repeat("0123456789", 20000), "text"),
+	},
+	{
+		name: "status", method: "GET", path: "/status/418",
+		check: wantStatus(http.StatusTeapot),
+	},
+	{
+		name: "redirect", method: "GET", path: "/redirect/2",
+		check: func(resp *http.Response, body []byte) error {
+			if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "/redirect/1" {
+				return fmt.Errorf("status %d, Location %q", resp.StatusCode, resp.Header.Get("Location"))
+			}
+			return nil
+		},
+	},
+}
+
+func wantStatus(code int) func(*http.Response, []byte) error {
+	return func(resp *http.Response, body []byte) error {
+		if resp.StatusCode != code {
+			return fmt.Errorf("status %d, want %d", resp.StatusCode, code)
+		}
+		return nil
+	}
+}
+
+func wantBody(body, encoding string) func(*http.Response, []byte) error {
+	return func(resp *http.Response, b []byte) error {
+		var e echo
+		if err := json.Unmarshal(b, &e); err != nil {
+			return err
+		}
+		if e.Body != body || e.BodyEncoding != encoding {
+			return fmt.Errorf("body %.40q (%s), want %.40q (%s)", e.Body, e.BodyEncoding, body, encoding)
+		}
+		return nil
+	}
+}
+
+var serverBinary string
+
+// TestMain builds the server once for the end-to-end tests, which run it as
+// a separate process.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "fcgi-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	serverBinary = filepath.Join(dir, "fcgi")
+	build := exec.Command("go", "build", "-o", serverBinary, ".")
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	status := 1
+	if err := build.Run(); err == nil {
+		status = m.Run()
+	}
+	os.RemoveAll(dir)
+	os.Exit(status)
+}
+
+// serverLog is where the server processes log: nowhere unless the tests
+// run with -v.
+func serverLog() io.Writer {
+	if testing.Verbose() {
+		return os.Stderr
+	}
+	return ioutil.Discard
+}
+
+// startFCGI starts args[0] with a -fcgi flag for a Unix socket of our
+// choosing, and waits for it to listen.
+func startFCGI(args []string, stderr io.Writer) (*fcgiChild, error) {
+	dir, err := ioutil.TempDir("", "fcgi-request")
+	if err != nil {
+		return nil, err
+	}
+	sock := filepath.Join(dir, "fcgi.sock")
+	cmd := exec.Command(args[0], append(args[1:], "-fcgi", "unix:"+sock)...)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	c := &fcgiChild{cmd, dir, fcgiclient.New("unix:" + sock)}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+			return c, nil
+		}
+		if time.Now().After(deadline) {
+			c.stop()
+			return nil, fmt.Errorf("%s did not listen on %s", args[0], sock)
+		}
+	}
+}
+
+func TestE2EStdin(t *testing.T) {
+	testFCGI(t, spawnFCGI)
+}
+
+func TestE2EUnixSocket(t *testing.T) {
+	testFCGI(t, startFCGI)
+}
+
+func TestE2ECGI(t *testing.T) {
+	runChecks(t, func(req *http.Request) (*http.Response, error) {
+		return doCGI(serverBinary, req, serverLog())
+	})
+}
+
+// testFCGI starts the server with start and sends it the checks through the
+// FastCGI client.
+func testFCGI(t *testing.T, start func([]string, io.Writer) (*fcgiChild, error)) {
+	child, err := start([]string{serverBinary}, serverLog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	runChecks(t, func(req *http.Request) (*http.Response, error) {
+		resp, res, err := child.client.DoHTTP(req)
+		if err == nil && res.AppStatus != 0 {
+			err = fmt.Errorf("application status %d", res.AppStatus)
+		}
+		return resp, err
+	})
+	if err := child.stop(); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+// runChecks sends the requests of e2eChecks with do, each in a subtest.
+func runChecks(t *testing.T, do func(*http.Request) (*http.Response, error)) {
+	for _, c := range e2eChecks {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.run(do); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestE2EInspect checks that the inspection API is only on the admin
+// listener, and that it redacts credentials.
+func TestE2EInspect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcgi-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, admin := filepath.Join(dir, "http.sock"), filepath.Join(dir, "admin.sock")
+	cmd := exec.Command(serverBinary, "-http", "unix:"+public, "-admin", "unix:"+admin)
+	cmd.Stderr = serverLog()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer (&fcgiChild{cmd: cmd, dir: dir}).stop()
+	publicClient, adminClient := unixClient(t, public), unixClient(t, admin)
+
+	req, _ := http.NewRequest("GET", "http://echo/hello", nil)
+	req.Header.Set("X-Request-ID", "e2e-inspect")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "session=secret")
+	resp, err := publicClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var page struct {
+		Total    int
+		Requests []captured
+	}
+	resp, err = publicClient.Get("http://echo/_echo/requests?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if page.Total != 0 || page.Requests != nil {
+		t.Errorf("the public listener serves the inspection API: %+v", page)
+	}
+
+	resp, err = adminClient.Get("http://admin/_echo/requests?header=X-Request-ID:e2e-inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || len(page.Requests) != 1 {
+		t.Fatalf("%d requests with ID e2e-inspect captured, want 1", page.Total)
+	}
+	for _, name := range []string{"Authorization", "Cookie"} {
+		if got := page.Requests[0].Headers[name]; len(got) != 1 || got[0] != "[redacted]" {
+			t.Errorf("%s %q, want it redacted", name, got)
+		}
+	}
+}
+
+// unixClient returns an HTTP client for the server listening on sock, once
+// it does.
+func unixClient(t *testing.T, sock string) *http.Client {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", sock)
+	}
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the server did not listen on %s", sock)
+		}
+	}
+	return &http.Client{Transport: &http.Transport{DialContext: dial}}
+}
+
+func (c *e2eCheck) run(do func(*http.Request) (*http.Response, error)) error {
+	req, err := http.NewRequest(c.method, c.path, bytes.NewReader(c.body))
+	if err != nil {
+		return err
+	}
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return c.check(resp, body)
+}
+
+// doCGI runs program as a CGI script for req, with the same variables the
+// FastCGI client sends.
+func doCGI(program string, req *http.Request, stderr io.Writer) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+	cmd := exec.Command(program)
+	for k, v := range fcgiclient.Params(req, len(body)) {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdin, cmd.Stderr = bytes.NewReader(body), stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return fcgiclient.ParseResponse(out)
+}
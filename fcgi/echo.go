@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// echo describes a request, for every output format.
+type echo struct {
+	Index        int64               `json:"index"`
+	RequestID    string              `json:"request_id"`
+	Method       string              `json:"method"`
+	URL          echoURL             `json:"url"`
+	Proto        string              `json:"proto"`
+	Host         string              `json:"host"`
+	RemoteAddr   string              `json:"remote_addr"`
+	RequestURI   string              `json:"request_uri"`
+	Query        map[string][]string `json:"query"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding"` // "text", or "base64" if the body is not UTF-8
+	TLS          *echoTLS            `json:"tls"`
+	Env          map[string]string   `json:"env,omitempty"` // CGI variables or FastCGI parameters, see gatewayEnv
+}
+
+type echoURL struct {
+	Full     string `json:"full"`
+	Scheme   string `json:"scheme"`
+	Host     string `json:"host"`
+	Path     string `json:"path"`
+	RawQuery string `json:"raw_query"`
+}
+
+type echoTLS struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipher_suite"`
+	ServerName         string `json:"server_name"`
+	NegotiatedProtocol string `json:"negotiated_protocol"`
+}
+
+func newEcho(r *http.Request, body []byte, index int64) *echo {
+	e := &echo{
+		Index:     index,
+		RequestID: requestID(r),
+		Method:    r.Method,
+		URL: echoURL{
+			Full:     r.URL.String(),
+			Scheme:   r.URL.Scheme,
+			Host:     r.URL.Host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		},
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		RequestURI: r.RequestURI,
+		Query:      r.URL.Query(),
+		Headers:    r.Header,
+		Env:        gatewayEnv(r),
+	}
+	e.Body, e.BodyEncoding = encodeBody(body)
+	if r.TLS != nil {
+		e.TLS = &echoTLS{
+			Version:            tls.VersionName(r.TLS.Version),
+			CipherSuite:        tls.CipherSuiteName(r.TLS.CipherSuite),
+			ServerName:         r.TLS.ServerName,
+			NegotiatedProtocol: r.TLS.NegotiatedProtocol,
+		}
+	}
+	return e
+}
+
+// encodeBody returns body as is if it is UTF-8 text, or else in base64, and
+// the encoding used: "text" or "base64". Echoes, captures and recordings
+// all keep bodies this way.
+func encodeBody(body []byte) (string, string) {
+	if !utf8.Valid(body) {
+		return base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	return string(body), "text"
+}
+
+// decodeBody returns the body that encodeBody gave s for.
+func decodeBody(s, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// QuotedBody is the body as a Go string literal, readable whatever it holds.
+func (e *echo) QuotedBody() string {
+	b, _ := decodeBody(e.Body, e.BodyEncoding)
+	return strconv.Quote(string(b))
+}
+
+func (e *echo) HeaderList() []nameValue { return sortedValues(e.Headers) }
+func (e *echo) QueryList() []nameValue  { return sortedValues(e.Query) }
+
+func (e *echo) EnvList() []nameValue {
+	m := make(map[string][]string, len(e.Env))
+	for k, v := range e.Env {
+		m[k] = []string{v}
+	}
+	return sortedValues(m)
+}
+
+type nameValue struct {
+	Name, Value string
+}
+
+// sortedValues flattens a header or query map, one entry per value.
+func sortedValues(m map[string][]string) []nameValue {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var values []nameValue
+	for _, name := range names {
+		for _, v := range m[name] {
+			values = append(values, nameValue{name, v})
+		}
+	}
+	return values
+}
+
+// html/template escapes every value, so requests are echoed back as text.
+var echoTemplate = template.Must(template.New("echo").Parse(`<html><head></head><body><p>Hello world from Go!</p>
+<table>
+<tr><td>Method</td><td>{{.Method}}</td></tr>
+<tr><td>URL</td><td>{{.URL.Full}}</td></tr>
+<tr><td>URL.Path</td><td>{{.URL.Path}}</td></tr>
+<tr><td>Proto</td><td>{{.Proto}}</td></tr>
+<tr><td>Host</td><td>{{.Host}}</td></tr>
+<tr><td>RemoteAddr</td><td>{{.RemoteAddr}}</td></tr>
+<tr><td>RequestURI</td><td>{{.RequestURI}}</td></tr>
+<tr><td>Body</td><td>{{.QuotedBody}}</td></tr>
+<tr><td>Request Index</td><td>{{.Index}}</td></tr>
+<tr><td>Request ID</td><td>{{.RequestID}}</td></tr>
+{{with .TLS}}<tr><td>TLS</td><td>{{.Version}} {{.CipherSuite}} {{.ServerName}} {{.NegotiatedProtocol}}</td></tr>
+{{end}}</table>
+<h2>Header</h2>
+<table>
+{{range .HeaderList}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+<h2>Query</h2>
+<table>
+{{range .QueryList}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{with .EnvList}}<h2>Environment</h2>
+<table>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}</body></html>
+`))
+
+// write renders e as html, json or text.
+func (e *echo) write(w http.ResponseWriter, format string) error {
+	w.Header().Add("Vary", "Accept")
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(e)
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return e.writeText(w)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return echoTemplate.Execute(w, e)
+}
+
+func (e *echo) writeText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Method: %s\n", e.Method)
+	fmt.Fprintf(&b, "URL: %s\n", e.URL.Full)
+	fmt.Fprintf(&b, "URL.Path: %s\n", e.URL.Path)
+	fmt.Fprintf(&b, "Proto: %s\n", e.Proto)
+	fmt.Fprintf(&b, "Host: %s\n", e.Host)
+	fmt.Fprintf(&b, "RemoteAddr: %s\n", e.RemoteAddr)
+	fmt.Fprintf(&b, "RequestURI: %s\n", e.RequestURI)
+	fmt.Fprintf(&b, "Body: %s\n", e.QuotedBody())
+	fmt.Fprintf(&b, "Request Index: %d\n", e.Index)
+	fmt.Fprintf(&b, "Request ID: %s\n", e.RequestID)
+	if e.TLS != nil {
+		fmt.Fprintf(&b, "TLS: %s %s %s %s\n", e.TLS.Version, e.TLS.CipherSuite, e.TLS.ServerName, e.TLS.NegotiatedProtocol)
+	}
+	for _, h := range e.HeaderList() {
+		fmt.Fprintf(&b, "Header: %s: %s\n", h.Name, h.Value)
+	}
+	for _, q := range e.QueryList() {
+		fmt.Fprintf(&b, "Query: %s=%s\n", q.Name, q.Value)
+	}
+	for _, v := range e.EnvList() {
+		fmt.Fprintf(&b, "Env: %s=%s\n", v.Name, v.Value)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var formats = map[string]string{
+	"text/html":        "html",
+	"application/json": "json",
+	"text/plain":       "text",
+}
+
+// outputFormat picks html, json or text from the format query parameter,
+// or else from the Accept header. HTML is the default.
+func outputFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		switch f {
+		case "html", "json", "text":
+			return f, nil
+		}
+		return "", fmt.Errorf("unknown format %q, want html, json or text", f)
+	}
+
+	best, bestQ := "html", 0.0
+	for _, mt := range parseQualities(r.Header.Get("Accept")) {
+		if f, ok := formats[mt.value]; ok && mt.q > bestQ {
+			best, bestQ = f, mt.q
+		}
+	}
+	return best, nil
+}
+
+// quality is a value of a header like Accept, with its q parameter.
+type quality struct {
+	value string // lower case, without parameters
+	q     float64
+}
+
+// parseQualities splits a header like Accept or Accept-Encoding into its
+// values, in order, with a q of 1 for those that do not say.
+func parseQualities(header string) []quality {
+	var qs []quality
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		v := quality{strings.ToLower(strings.TrimSpace(fields[0])), 1.0}
+		for _, param := range fields[1:] {
+			if p := strings.TrimSpace(param); strings.HasPrefix(p, "q=") {
+				if f, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					v.q = f
+				}
+			}
+		}
+		qs = append(qs, v)
+	}
+	return qs
+}
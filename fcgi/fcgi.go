@@ -1,58 +1,189 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"flag"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/fcgi"
+	"net/http/cgi"
 	"os"
 	"runtime"
 	"strconv"
+	"time"
 )
 
-var app_addr string
-var index int
+var index int64 // number of requests served, updated atomically
+var captures *captureStore
+
+// Set by flags in main, see newHandler.
+var (
+	maxBodySize    int64
+	requestTimeout time.Duration
+	corsRules      []corsRule
+)
 
 func init() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
-	app_addr = os.Getenv("APP_ADDR") // e.g. "0.0.0.0:8080" or ""
 }
 
 func ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var body = ""
-	if b, err := ioutil.ReadAll(r.Body); err == nil {
-		body = strconv.Quote(string(b))
-	}
-
-	headers := w.Header()
-	headers.Add("Content-Type", "text/html")
-	io.WriteString(w, "<html><head></head><body><p>Hello world from Go!</p><table>")
-	io.WriteString(w, fmt.Sprintf("<tr><td>Method</td><td>%s</td></tr>", r.Method))
-	io.WriteString(w, fmt.Sprintf("<tr><td>URL</td><td>%s</td></tr>", r.URL))
-	io.WriteString(w, fmt.Sprintf("<tr><td>URL.Path</td><td>%s</td></tr>", r.URL.Path))
-	io.WriteString(w, fmt.Sprintf("<tr><td>Proto</td><td>%s</td></tr>", r.Proto))
-	io.WriteString(w, fmt.Sprintf("<tr><td>Host</td><td>%s</td></tr>", r.Host))
-	io.WriteString(w, fmt.Sprintf("<tr><td>RemoteAddr</td><td>%s</td></tr>", r.RemoteAddr))
-	io.WriteString(w, fmt.Sprintf("<tr><td>RequestURI</td><td>%s</td></tr>", r.RequestURI))
-	io.WriteString(w, fmt.Sprintf("<tr><td>Header</td><td>%s</td></tr>", r.Header))
-	io.WriteString(w, fmt.Sprintf("<tr><td>Body</td><td>%s</td></tr>", body))
-	io.WriteString(w, fmt.Sprintf("<tr><td>Request Index</td><td>%d</td></tr>", index))
-	io.WriteString(w, "</table></body></html>")
-	index = index + 1
+	format, err := outputFormat(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	e := newEcho(r, body, requestIndex(r))
+
+	if err := e.write(w, format); err != nil {
+		log.Print(err)
+	}
 }
 
 func main() {
-	http.HandleFunc("/", ServeHTTP)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replayMain(os.Args[2:])
+			return
+		case "fcgi-request":
+			fcgiRequestMain(os.Args[2:])
+			return
+		}
+	}
 
-	var err error
-	if app_addr != "" { // Run as a local web server
-		err = http.ListenAndServe(app_addr, nil)
-	} else { // Run as FCGI via standard I/O
-		err = fcgi.Serve(nil, nil)
+	httpAddrs := envList("APP_ADDR", ",")
+	fcgiAddrs := envList("FCGI_ADDR", ",")
+	corsFlags := envList("CORS", " ")
+	flag.Var(httpAddrs, "http", "serve HTTP on this host:port or unix:/path (repeatable; default $APP_ADDR)")
+	flag.Var(fcgiAddrs, "fcgi", "serve FastCGI on this host:port or unix:/path (repeatable; default $FCGI_ADDR)")
+	adminAddr := flag.String("admin", os.Getenv("ADMIN_ADDR"), "serve /metrics, pprof, expvar and the inspection API on this host:port or unix:/path")
+	socketMode := flag.String("socket-mode", envString("SOCKET_MODE", "0660"), "permissions of Unix sockets")
+	captureSize := flag.Int("capture", envInt("CAPTURE_SIZE", 1000), "number of recent requests kept in memory")
+	captureBytes := flag.Int64("capture-bytes", int64(envInt("CAPTURE_BYTES", 64<<20)), "total size of the request bodies kept in memory, 0 for no limit")
+	captureFile := flag.String("capture-file", os.Getenv("CAPTURE_FILE"), "append every request to this file as JSON lines")
+	accessLogPath := flag.String("access-log", os.Getenv("ACCESS_LOG"), "write the access log to this file instead of standard error")
+	accessLogFormat := flag.String("access-log-format", envString("ACCESS_LOG_FORMAT", "combined"), "access log format: clf, combined or json")
+	accessLogMaxSize := flag.Int64("access-log-max-size", int64(envInt("ACCESS_LOG_MAX_SIZE", 100<<20)), "rotate the access log file at this many bytes, 0 for never")
+	accessLogMaxFiles := flag.Int("access-log-max-files", envInt("ACCESS_LOG_MAX_FILES", 5), "number of rotated access log files kept")
+	flag.Int64Var(&maxBodySize, "max-body", int64(envInt("MAX_BODY", 10<<20)), "answer 413 to request bodies larger than this many bytes, 0 for no limit")
+	flag.DurationVar(&requestTimeout, "timeout", envDuration("REQUEST_TIMEOUT", time.Minute), "deadline for each request, 0 for none")
+	flag.Var(corsFlags, "cors", "allow cross-origin requests as /PREFIX=ORIGIN[,ORIGIN...] (repeatable; default $CORS, space-separated)")
+	mockFile := flag.String("mock", os.Getenv("MOCK_RULES"), "answer requests matching the rules in this JSON file, see mock.go")
+	upstream := flag.String("upstream", os.Getenv("UPSTREAM"), "forward requests to this URL instead of echoing them, see proxy.go")
+	recordFile := flag.String("record", os.Getenv("RECORD_FILE"), "record the requests forwarded and the upstream responses in this file, HAR if it ends in .har, else JSON lines")
+	replayFile := flag.String("replay", os.Getenv("REPLAY_FILE"), "answer requests recorded in this file with the recorded responses")
+	shutdownTimeout := flag.Duration("shutdown-timeout", envDuration("SHUTDOWN_TIMEOUT", 30*time.Second), "how long to wait for requests in flight when stopping")
+	flag.Parse()
+
+	mode, err := parseMode(*socketMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range corsFlags.values {
+		rule, err := parseCORS(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		corsRules = append(corsRules, rule)
+	}
+	if *mockFile != "" {
+		if mocks, err = newMockServer(*mockFile, time.Second); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *upstream != "" || *recordFile != "" || *replayFile != "" {
+		if proxy, err = newRecordingProxy(*upstream, *recordFile, *replayFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if captures, err = newCaptureStore(*captureSize, *captureBytes, *captureFile); err != nil {
+		log.Fatal(err)
 	}
+	if accessLog, err = newAccessLogger(*accessLogFormat, *accessLogPath, *accessLogMaxSize, *accessLogMaxFiles); err != nil {
+		log.Fatal(err)
+	}
+	accessLog.reopenOnSIGHUP()
+	if cgiMode {
+		err := cgi.Serve(newHandler())
+		if cerr := captures.close(); err == nil {
+			err = cerr
+		}
+		if rerr := proxy.close(); err == nil {
+			err = rerr
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	servers, err := listenAll(httpAddrs.values, fcgiAddrs.values, *adminAddr, mode, newHandler())
 	if err != nil {
 		log.Fatal(err)
 	}
+	serveUntilSignal(servers, *shutdownTimeout)
+}
+
+// newHandler routes the metrics, the mock rules and, for every other path,
+// the upstream in proxy mode or else the diagnostic endpoints and the echo
+// page. Only the metrics escape the body limit, the deadline and the
+// capture. The inspection API is on the admin listener, see adminHandler.
+func newHandler() http.Handler {
+	var app http.Handler
+	if proxy != nil {
+		app = proxy
+	} else {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", ServeHTTP)
+		handleBin(mux)
+		app = mux
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", chain(app, withTimeout(requestTimeout), withBodyLimit(maxBodySize), withCapture, withMock))
+	mux.HandleFunc("/metrics", serveMetrics)
+	return chain(mux,
+		withDrain,
+		withRequestID,
+		withAccessLog,
+		withMetrics,
+		withRecovery,
+		withCORS(corsRules),
+		withCompression,
+	)
+}
+
+// envString returns the environment variable name, or def if it is not set.
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns the environment variable name as an int, or def if it is
+// not set.
+func envInt(name string, def int) int {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		return n
+	}
+	return def
+}
+
+// envDuration returns the environment variable name as a duration, or def if
+// it is not set.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		return d
+	}
+	return def
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestConcurrentRequests hammers the handler from many goroutines and checks
+// that every request got its own index and ID. Run it with -race.
+func TestConcurrentRequests(t *testing.T) {
+	const n, clients = 1000, 50
+	saved := captures
+	defer func() { captures = saved }()
+	captures, _ = newCaptureStore(n/2, 0, "")
+	srv := httptest.NewServer(newHandler())
+	defer srv.Close()
+
+	start := atomic.LoadInt64(&index)
+	results := make(chan *echo, n)
+	var next int64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.AddInt64(&next, 1) <= n {
+				e, err := getEcho(srv.URL)
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				results <- e
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	indexes := make(map[int64]bool)
+	ids := make(map[string]bool)
+	for e := range results {
+		if indexes[e.Index] {
+			t.Errorf("index %d served twice", e.Index)
+		}
+		if ids[e.RequestID] {
+			t.Errorf("request ID %s served twice", e.RequestID)
+		}
+		indexes[e.Index], ids[e.RequestID] = true, true
+	}
+	if got := len(captures.all()); got != n/2 {
+		t.Errorf("%d requests captured, want %d", got, n/2)
+	}
+	for i := start; i < start+n; i++ {
+		if !indexes[i] {
+			t.Errorf("index %d never served", i)
+		}
+	}
+}
+
+func getEcho(url string) (*echo, error) {
+	resp, err := http.Get(url + "/?format=json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var e echo
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return nil, err
+	}
+	if got := resp.Header.Get("X-Request-ID"); got != e.RequestID {
+		return nil, fmt.Errorf("X-Request-ID %q, echoed %q", got, e.RequestID)
+	}
+	return &e, nil
+}
@@ -0,0 +1,309 @@
+// Package fcgiclient is a FastCGI client, enough to talk to the fcgi echo
+// server (or any FastCGI responder) the way nginx or Apache would:
+//
+//	c := fcgiclient.New("unix:/run/echo.sock")
+//	resp, res, err := c.DoHTTP(req)
+//
+// One request is sent per connection: BEGIN_REQUEST, the PARAMS stream and
+// the STDIN stream go out, then STDOUT and STDERR are read up to
+// END_REQUEST. The record layout is in the FastCGI 1.0 specification.
+package fcgiclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	version = 1
+
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+
+	requestComplete = 0 // protocol status in END_REQUEST
+
+	maxContent = 65535
+)
+
+// Result is what a FastCGI application sent back for a request.
+type Result struct {
+	Stdout         []byte
+	Stderr         []byte
+	AppStatus      uint32
+	ProtocolStatus uint8
+}
+
+// Client sends requests to one FastCGI application.
+type Client struct {
+	network, addr string
+
+	// Timeout limits the time a request takes, from dialing to reading
+	// END_REQUEST. Zero means no limit.
+	Timeout time.Duration
+}
+
+// New returns a client for host:port, or for a Unix socket given as
+// unix:/path or any path with a slash, as in the -fcgi flag of the server.
+func New(addr string) *Client {
+	if strings.HasPrefix(addr, "unix:") {
+		return &Client{network: "unix", addr: strings.TrimPrefix(addr, "unix:")}
+	}
+	if strings.Contains(addr, "/") {
+		return &Client{network: "unix", addr: addr}
+	}
+	return &Client{network: "tcp", addr: addr}
+}
+
+// Do sends one request with params and the body read from stdin.
+func (c *Client) Do(params map[string]string, stdin io.Reader) (*Result, error) {
+	conn, err := net.DialTimeout(c.network, c.addr, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+
+	const id = 1
+	w := bufio.NewWriter(conn)
+	begin := []byte{0, roleResponder, 0, 0, 0, 0, 0, 0} // role, flags: close the connection when done
+	if err := writeRecord(w, typeBeginRequest, id, begin); err != nil {
+		return nil, err
+	}
+	if err := writeStream(w, typeParams, id, bytes.NewReader(encodeParams(params))); err != nil {
+		return nil, err
+	}
+	if stdin == nil {
+		stdin = bytes.NewReader(nil)
+	}
+	if err := writeStream(w, typeStdin, id, stdin); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var res Result
+	var stdout, stderr bytes.Buffer
+	r := bufio.NewReader(conn)
+	for {
+		typ, reqID, content, err := readRecord(r)
+		if err == io.EOF {
+			return nil, errors.New("fcgi: connection closed before END_REQUEST")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if reqID != id {
+			continue
+		}
+		switch typ {
+		case typeStdout:
+			stdout.Write(content)
+		case typeStderr:
+			stderr.Write(content)
+		case typeEndRequest:
+			if len(content) < 5 {
+				return nil, errors.New("fcgi: short END_REQUEST record")
+			}
+			res.AppStatus = binary.BigEndian.Uint32(content)
+			res.ProtocolStatus = content[4]
+			res.Stdout, res.Stderr = stdout.Bytes(), stderr.Bytes()
+			if res.ProtocolStatus != requestComplete {
+				return &res, fmt.Errorf("fcgi: request rejected, protocol status %d", res.ProtocolStatus)
+			}
+			return &res, nil
+		}
+	}
+}
+
+// DoHTTP sends req as a web server would, and parses the CGI-style output
+// into an http.Response.
+func (c *Client) DoHTTP(req *http.Request) (*http.Response, *Result, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, nil, err
+		}
+		req.Body.Close()
+	}
+	res, err := c.Do(Params(req, len(body)), bytes.NewReader(body))
+	if err != nil {
+		return nil, res, err
+	}
+	resp, err := ParseResponse(res.Stdout)
+	if err != nil {
+		return nil, res, err
+	}
+	resp.Request = req
+	return resp, res, nil
+}
+
+// Params returns the CGI variables a web server would send for req, with a
+// body of contentLength bytes.
+func Params(req *http.Request, contentLength int) map[string]string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	serverName, serverPort := host, "80"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		serverName, serverPort = h, p
+	}
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "fcgi-request",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"SERVER_NAME":       serverName,
+		"SERVER_PORT":       serverPort,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"SCRIPT_NAME":       "",
+		"PATH_INFO":         req.URL.Path,
+		"QUERY_STRING":      req.URL.RawQuery,
+		"REMOTE_ADDR":       "127.0.0.1",
+		"REMOTE_PORT":       "0",
+		"HTTP_HOST":         host,
+	}
+	if contentLength > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		params["CONTENT_LENGTH"] = strconv.Itoa(contentLength)
+	}
+	for name, values := range req.Header {
+		key := strings.ToUpper(strings.Replace(name, "-", "_", -1))
+		switch key {
+		case "CONTENT_TYPE":
+			params[key] = values[0]
+		case "CONTENT_LENGTH", "HOST":
+		default:
+			params["HTTP_"+key] = strings.Join(values, ", ")
+		}
+	}
+	return params
+}
+
+// ParseResponse parses the output of a FastCGI or CGI application: headers,
+// with the status in a Status header, then the body.
+func ParseResponse(out []byte) (*http.Response, error) {
+	r := bufio.NewReader(bytes.NewReader(out))
+	h, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("fcgi: bad response headers: %v", err)
+	}
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header(h),
+	}
+	if s := resp.Header.Get("Status"); s != "" {
+		code, err := strconv.Atoi(strings.SplitN(s, " ", 2)[0])
+		if err != nil {
+			return nil, fmt.Errorf("fcgi: bad Status header %q", s)
+		}
+		resp.Status, resp.StatusCode = s, code
+		resp.Header.Del("Status")
+	}
+	body, _ := ioutil.ReadAll(r)
+	resp.ContentLength = int64(len(body))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func writeRecord(w io.Writer, typ uint8, id uint16, content []byte) error {
+	padding := -len(content) & 7
+	h := []byte{version, typ, byte(id >> 8), byte(id), byte(len(content) >> 8), byte(len(content)), byte(padding), 0}
+	if _, err := w.Write(h); err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, padding))
+	return err
+}
+
+// writeStream sends r as records of type typ, ended by an empty one.
+func writeStream(w io.Writer, typ uint8, id uint16, r io.Reader) error {
+	buf := make([]byte, maxContent)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if werr := writeRecord(w, typ, id, buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return writeRecord(w, typ, id, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func readRecord(r io.Reader) (typ uint8, id uint16, content []byte, err error) {
+	var h [8]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	if h[0] != version {
+		err = fmt.Errorf("fcgi: unknown record version %d", h[0])
+		return
+	}
+	typ, id = h[1], binary.BigEndian.Uint16(h[2:])
+	content = make([]byte, int(binary.BigEndian.Uint16(h[4:]))+int(h[6]))
+	if _, err = io.ReadFull(r, content); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	content = content[:len(content)-int(h[6])]
+	return
+}
+
+// encodeParams encodes name-value pairs, with lengths in one byte below 128
+// and in four bytes with the high bit set otherwise.
+func encodeParams(params map[string]string) []byte {
+	var b bytes.Buffer
+	writeLen := func(n int) {
+		if n < 128 {
+			b.WriteByte(byte(n))
+			return
+		}
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(n)|1<<31)
+		b.Write(l[:])
+	}
+	for name, value := range params {
+		writeLen(len(name))
+		writeLen(len(value))
+		b.WriteString(name)
+		b.WriteString(value)
+	}
+	return b.Bytes()
+}
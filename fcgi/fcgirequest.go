@@ -0,0 +1,189 @@
+package main
+
+// The fcgi-request command sends one request over FastCGI, like curl does
+// over HTTP:
+//
+//	go run . fcgi-request -addr unix:/run/echo.sock -H 'Accept: application/json' /hello
+//	go run . fcgi-request -X POST -d @body.json /anything -- ./other-fcgi-app
+//
+// Without -addr it spawns a FastCGI application with a listening socket as
+// its standard input, as a web server would: the command after --, or else
+// this server itself.
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yulvil/golang-examples/fcgi/fcgiclient"
+)
+
+// fcgiChild is a FastCGI application started by us.
+type fcgiChild struct {
+	cmd    *exec.Cmd
+	dir    string
+	client *fcgiclient.Client
+}
+
+// spawnFCGI starts args[0] with a listening Unix socket as its standard
+// input.
+func spawnFCGI(args []string, stderr io.Writer) (*fcgiChild, error) {
+	dir, err := ioutil.TempDir("", "fcgi-request")
+	if err != nil {
+		return nil, err
+	}
+	sock := filepath.Join(dir, "fcgi.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	f, err := ul.File()
+	l.Close()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	defer f.Close()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stderr = f, stderr
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &fcgiChild{cmd, dir, fcgiclient.New("unix:" + sock)}, nil
+}
+
+// stop asks the child to shut down and waits for it.
+func (c *fcgiChild) stop() error {
+	defer os.RemoveAll(c.dir)
+	c.cmd.Process.Signal(syscall.SIGTERM)
+	done := make(chan error, 1)
+	go func() { done <- c.cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		c.cmd.Process.Kill()
+		return <-done
+	}
+}
+
+func fcgiRequestMain(args []string) {
+	fs := flag.NewFlagSet("fcgi-request", flag.ExitOnError)
+	addr := fs.String("addr", "", "FastCGI application at host:port or unix:/path; by default one is spawned")
+	method := fs.String("X", "", "request method (default GET, or POST with -d)")
+	data := fs.String("d", "", "request body, or @file to read it from a file")
+	include := fs.Bool("i", false, "print the response status and headers")
+	verbose := fs.Bool("v", false, "show what a spawned application logs")
+	timeout := fs.Duration("timeout", 30*time.Second, "time limit for the request, 0 for none")
+	headers := &listFlag{}
+	fs.Var(headers, "H", "request header as 'Name: value' (repeatable)")
+	fs.Parse(args)
+	if fs.NArg() < 1 || (fs.NArg() > 1 && fs.Arg(1) != "--") {
+		fmt.Fprintln(os.Stderr, "usage: fcgi fcgi-request [-addr ADDR] [-X METHOD] [-H HEADER]... [-d BODY] [-i] [-timeout D] PATH [-- COMMAND ARGS...]")
+		os.Exit(2)
+	}
+
+	var body io.Reader
+	if *data != "" {
+		if *method == "" {
+			*method = "POST"
+		}
+		if strings.HasPrefix(*data, "@") {
+			f, err := os.Open((*data)[1:])
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			body = f
+		} else {
+			body = strings.NewReader(*data)
+		}
+	}
+	if *method == "" {
+		*method = "GET"
+	}
+	u, err := url.Parse(fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	req, err := http.NewRequest(*method, u.String(), body)
+	if err != nil {
+		fatal(err)
+	}
+	for _, h := range headers.values {
+		i := strings.Index(h, ":")
+		if i < 0 {
+			fatal(fmt.Errorf("header %q is not 'Name: value'", h))
+		}
+		name, value := strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:])
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Add(name, value)
+		}
+	}
+
+	client := fcgiclient.New(*addr)
+	var child *fcgiChild
+	if *addr == "" {
+		command := fs.Args()[1:]
+		if len(command) > 0 {
+			command = command[1:] // after --
+		}
+		if len(command) == 0 {
+			self, err := os.Executable()
+			if err != nil {
+				fatal(err)
+			}
+			command = []string{self}
+		}
+		var stderr io.Writer = ioutil.Discard
+		if *verbose {
+			stderr = os.Stderr
+		}
+		if child, err = spawnFCGI(command, stderr); err != nil {
+			fatal(err)
+		}
+		client = child.client
+	}
+	client.Timeout = *timeout
+
+	// The response is read in full, so the child can go before we print it
+	// or exit on an error.
+	resp, res, err := client.DoHTTP(req)
+	if child != nil {
+		child.stop()
+	}
+	if res != nil && len(res.Stderr) > 0 {
+		os.Stderr.Write(res.Stderr)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if *include {
+		fmt.Printf("%s %s\r\n", resp.Proto, resp.Status)
+		resp.Header.Write(os.Stdout)
+		fmt.Print("\r\n")
+	}
+	io.Copy(os.Stdout, resp.Body)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "fcgi-request:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,286 @@
+package main
+
+// The inspection API shows the captured requests, on the -admin listener
+// only: they carry other clients' headers. Credentials, such as the
+// Authorization and Cookie headers, are redacted all the same.
+//
+//	GET    /_echo/                     a page following new requests live
+//	GET    /_echo/requests             newest first; offset, limit, method, path, header=Name:Value
+//	GET    /_echo/requests/{id}        one request
+//	GET    /_echo/requests/stream      new requests as Server-Sent Events, same filters
+//	DELETE /_echo/requests             forget the requests kept in memory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const inspectPrefix = "/_echo/"
+
+func handleInspect(mux *http.ServeMux) {
+	mux.HandleFunc(inspectPrefix, serveInspectPage)
+	mux.HandleFunc(inspectPrefix+"requests", serveRequests)
+	mux.HandleFunc(inspectPrefix+"requests/", serveRequest)
+}
+
+// requestFilter selects captured requests by method, path and header.
+type requestFilter struct {
+	method string
+	path   string // a path.Match pattern
+	header string
+	value  string
+}
+
+func newRequestFilter(q url.Values) (*requestFilter, error) {
+	f := &requestFilter{method: q.Get("method"), path: q.Get("path")}
+	if _, err := path.Match(f.path, ""); err != nil {
+		return nil, fmt.Errorf("path %q: %v", f.path, err)
+	}
+	if h := q.Get("header"); h != "" {
+		i := strings.Index(h, ":")
+		if i < 0 {
+			f.header = h
+		} else {
+			f.header, f.value = strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:])
+		}
+	}
+	return f, nil
+}
+
+// credentialHeaders are redacted in what the inspection API shows.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// redacted returns c with the values of credentialHeaders replaced.
+func redacted(c *captured) *captured {
+	r := *c
+	r.Headers = make(map[string][]string, len(c.Headers))
+	for name, values := range c.Headers {
+		r.Headers[name] = values
+	}
+	for _, name := range credentialHeaders {
+		if values, ok := r.Headers[name]; ok {
+			r.Headers[name] = make([]string, len(values))
+			for i := range values {
+				r.Headers[name][i] = "[redacted]"
+			}
+		}
+	}
+	return &r
+}
+
+func (f *requestFilter) match(c *captured) bool {
+	if f.method != "" && !strings.EqualFold(f.method, c.Method) {
+		return false
+	}
+	if f.path != "" {
+		u, err := url.ParseRequestURI(c.URL)
+		if err != nil {
+			return false
+		}
+		if ok, _ := path.Match(f.path, u.Path); !ok {
+			return false
+		}
+	}
+	if f.header != "" {
+		values, ok := http.Header(c.Headers)[http.CanonicalHeaderKey(f.header)]
+		if !ok {
+			return false
+		}
+		if f.value == "" {
+			return true
+		}
+		for _, v := range values {
+			if v == f.value {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func serveRequests(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
+	case "DELETE":
+		captures.clear()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f, err := newRequestFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := queryInt(q, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(q, "limit", 50)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var matched []*captured
+	all := captures.all()
+	for i := len(all) - 1; i >= 0; i-- {
+		if f.match(all[i]) {
+			matched = append(matched, all[i])
+		}
+	}
+	page := struct {
+		Total    int         `json:"total"`
+		Offset   int         `json:"offset"`
+		Limit    int         `json:"limit"`
+		Requests []*captured `json:"requests"`
+	}{len(matched), offset, limit, []*captured{}}
+	if offset < len(matched) {
+		matched = matched[offset:]
+		if len(matched) > limit {
+			matched = matched[:limit]
+		}
+		for _, c := range matched {
+			page.Requests = append(page.Requests, redacted(c))
+		}
+	}
+	writeJSON(w, page)
+}
+
+func serveRequest(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, inspectPrefix+"requests/")
+	if id == "stream" {
+		serveStream(w, r)
+		return
+	}
+	c := captures.find(id)
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, redacted(c))
+}
+
+// serveStream sends every new request matching the filter as an event, until
+// the client goes away or the server shuts down.
+func serveStream(w http.ResponseWriter, r *http.Request) {
+	f, err := newRequestFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ch, cancel := captures.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	io.WriteString(w, ": listening\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case c := <-ch:
+			if !f.match(c) {
+				continue
+			}
+			data, err := json.Marshal(redacted(c))
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %s\nevent: request\ndata: %s\n\n", c.ID, data)
+		case <-ping.C:
+			io.WriteString(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		case <-stopping:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func queryInt(q url.Values, name string, def int) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s %q is not a non-negative integer", name, v)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func serveInspectPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != inspectPrefix {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, inspectPage)
+}
+
+const inspectPage = `<!DOCTYPE html>
+<html><head><title>Requests</title></head><body>
+<p>Filter: method <input id="method" size="6"> path <input id="path" placeholder="/hooks/*">
+header <input id="header" placeholder="X-Event: push"> <button id="go">Follow</button>
+<button id="clear">Clear</button></p>
+<div id="out"></div>
+<script>
+var source;
+function follow() {
+  if (source) source.close();
+  var q = new URLSearchParams();
+  for (var name of ["method", "path", "header"]) {
+    var v = document.getElementById(name).value;
+    if (v) q.set(name, v);
+  }
+  var out = document.getElementById("out");
+  out.textContent = "";
+  var show = function(c) {
+    var pre = document.createElement("pre");
+    pre.textContent = JSON.stringify(c, null, 2);
+    out.insertBefore(pre, out.firstChild);
+  };
+  fetch("requests?" + q).then(function(r) { return r.json(); }).then(function(page) {
+    page.requests.reverse().forEach(show);
+    source = new EventSource("requests/stream?" + q);
+    source.addEventListener("request", function(e) { show(JSON.parse(e.data)); });
+  });
+}
+document.getElementById("go").onclick = follow;
+document.getElementById("clear").onclick = function() {
+  fetch("requests", {method: "DELETE"}).then(follow);
+};
+follow();
+</script>
+</body></html>
+`
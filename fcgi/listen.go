@@ -0,0 +1,202 @@
+package main
+
+// Listeners: HTTP and FastCGI can each listen on any number of addresses,
+// given as host:port for TCP or unix:/path (or any path with a slash) for a
+// Unix domain socket:
+//
+//	fcgi -http :8080 -fcgi unix:/run/echo.sock -fcgi 127.0.0.1:9000
+//
+// APP_ADDR and FCGI_ADDR hold comma-separated defaults. With neither, the
+// server speaks FastCGI on standard input, as when spawned by a web server.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// listFlag is a flag that can be repeated. Its default comes from the
+// environment, and is replaced by the first value on the command line.
+type listFlag struct {
+	values []string
+	set    bool
+}
+
+// envList splits the environment variable name at sep for the default.
+func envList(name, sep string) *listFlag {
+	f := &listFlag{}
+	for _, v := range strings.Split(os.Getenv(name), sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			f.values = append(f.values, v)
+		}
+	}
+	return f
+}
+
+func (f *listFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(f.values, ",")
+}
+
+func (f *listFlag) Set(v string) error {
+	if !f.set {
+		f.values, f.set = nil, true
+	}
+	f.values = append(f.values, v)
+	return nil
+}
+
+// server is one listener and the protocol spoken on it.
+type server struct {
+	name  string // e.g. "FastCGI on unix:/run/echo.sock"
+	serve func() error
+	stop  func(ctx context.Context) error
+}
+
+func httpServer(l net.Listener, addr string, h http.Handler) server {
+	srv := &http.Server{Handler: h}
+	return server{
+		name:  "HTTP on " + addr,
+		serve: func() error { return srv.Serve(l) },
+		stop:  srv.Shutdown,
+	}
+}
+
+// adminServer serves adminHandler, whatever h is.
+func adminServer(l net.Listener, addr string, h http.Handler) server {
+	s := httpServer(l, addr, adminHandler())
+	s.name = "admin " + s.name
+	return s
+}
+
+// fcgiServer serves FastCGI on l. The fcgi package cannot drain its
+// connections, so stopping closes l and withDrain waits for the requests.
+func fcgiServer(l net.Listener, addr string, h http.Handler) server {
+	return server{
+		name:  "FastCGI on " + addr,
+		serve: func() error { return fcgi.Serve(l, h) },
+		stop:  func(ctx context.Context) error { return l.Close() },
+	}
+}
+
+// listen opens a TCP or Unix socket listener for addr. Unix sockets get the
+// permissions in mode, and a stale socket left by a dead process is removed.
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	network, address := "tcp", addr
+	if strings.HasPrefix(addr, "unix:") {
+		network, address = "unix", strings.TrimPrefix(addr, "unix:")
+	} else if strings.Contains(addr, "/") {
+		network = "unix"
+	}
+	if network == "tcp" {
+		return net.Listen(network, address)
+	}
+
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(address, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing answers on it.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	if !isConnRefused(err) {
+		return err
+	}
+	log.Printf("removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+func isConnRefused(err error) bool {
+	if op, ok := err.(*net.OpError); ok {
+		if se, ok := op.Err.(*os.SyscallError); ok {
+			return se.Err == syscall.ECONNREFUSED
+		}
+	}
+	return false
+}
+
+// parseMode parses octal permissions such as 0660.
+func parseMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("socket mode %q is not octal permissions like 0660", s)
+	}
+	return os.FileMode(m), nil
+}
+
+// listenAll opens every HTTP and FastCGI listener, or FastCGI on standard
+// input if there are none, and the admin listener if adminAddr is set.
+func listenAll(httpAddrs, fcgiAddrs []string, adminAddr string, mode os.FileMode, h http.Handler) ([]server, error) {
+	var servers []server
+	var listeners []net.Listener
+	add := func(addr string, newServer func(net.Listener, string, http.Handler) server) error {
+		l, err := listen(addr, mode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, l)
+		servers = append(servers, newServer(l, addr, h))
+		return nil
+	}
+	for _, addr := range httpAddrs {
+		if err := add(addr, httpServer); err != nil {
+			return nil, err
+		}
+	}
+	for _, addr := range fcgiAddrs {
+		if err := add(addr, fcgiServer); err != nil {
+			return nil, err
+		}
+	}
+	if len(servers) == 0 {
+		l, err := net.FileListener(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		servers = append(servers, fcgiServer(l, "standard input", h))
+	}
+	if adminAddr != "" {
+		if err := add(adminAddr, adminServer); err != nil {
+			return nil, err
+		}
+	}
+	return servers, nil
+}
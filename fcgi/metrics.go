@@ -0,0 +1,186 @@
+package main
+
+// Metrics in the Prometheus text format, at /metrics:
+//
+//	fcgi_requests_total{method="GET",status="200"} 12
+//	fcgi_request_duration_seconds_bucket{le="0.005"} 10
+//	fcgi_requests_in_flight 1
+//	fcgi_request_bytes_total 512
+//	fcgi_response_bytes_total 8192
+//
+// With -admin, a separate listener also serves them, with net/http/pprof at
+// /debug/pprof/, expvar at /debug/vars and the inspection API at /_echo/.
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var metrics = newRequestMetrics()
+
+func init() {
+	expvar.Publish("fcgi", expvar.Func(func() interface{} { return metrics.summary() }))
+}
+
+// durationBuckets are the upper bounds of the latency histogram, in seconds.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type methodStatus struct {
+	method string
+	status int
+}
+
+type requestMetrics struct {
+	mu       sync.Mutex
+	requests map[methodStatus]uint64
+	buckets  []uint64 // per bucket, not cumulative; the last one is +Inf
+	sum      float64
+	count    uint64
+
+	bytesIn, bytesOut int64 // updated atomically
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{
+		requests: make(map[methodStatus]uint64),
+		buckets:  make([]uint64, len(durationBuckets)+1),
+	}
+}
+
+func (m *requestMetrics) observe(method string, status int, d time.Duration) {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "CONNECT", "TRACE":
+	default:
+		method = "other" // keep the number of series bounded
+	}
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(durationBuckets, secs)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[methodStatus{method, status}]++
+	m.buckets[i]++
+	m.sum += secs
+	m.count++
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n *int64
+}
+
+func (r countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+// withMetrics records every request in metrics.
+func withMetrics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if r.Body != nil {
+			r.Body = countingReader{r.Body, &metrics.bytesIn}
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		atomic.AddInt64(&metrics.bytesOut, rec.bytes)
+		metrics.observe(r.Method, rec.status, time.Since(start))
+	})
+}
+
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
+
+func (m *requestMetrics) write(w io.Writer) {
+	var b strings.Builder
+	m.mu.Lock()
+	keys := make([]methodStatus, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].status < keys[j].status
+	})
+	b.WriteString("# HELP fcgi_requests_total Requests served, by method and status.\n")
+	b.WriteString("# TYPE fcgi_requests_total counter\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "fcgi_requests_total{method=%q,status=\"%d\"} %d\n", k.method, k.status, m.requests[k])
+	}
+
+	b.WriteString("# HELP fcgi_request_duration_seconds Time to serve a request.\n")
+	b.WriteString("# TYPE fcgi_request_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, n := range m.buckets {
+		cumulative += n
+		le := "+Inf"
+		if i < len(durationBuckets) {
+			le = strconv.FormatFloat(durationBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(&b, "fcgi_request_duration_seconds_bucket{le=%q} %d\n", le, cumulative)
+	}
+	fmt.Fprintf(&b, "fcgi_request_duration_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(&b, "fcgi_request_duration_seconds_count %d\n", m.count)
+	m.mu.Unlock()
+
+	b.WriteString("# HELP fcgi_requests_in_flight Requests being served.\n")
+	b.WriteString("# TYPE fcgi_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "fcgi_requests_in_flight %d\n", atomic.LoadInt64(&inFlightCount))
+	b.WriteString("# HELP fcgi_request_bytes_total Bytes read from request bodies.\n")
+	b.WriteString("# TYPE fcgi_request_bytes_total counter\n")
+	fmt.Fprintf(&b, "fcgi_request_bytes_total %d\n", atomic.LoadInt64(&m.bytesIn))
+	b.WriteString("# HELP fcgi_response_bytes_total Bytes written in response bodies.\n")
+	b.WriteString("# TYPE fcgi_response_bytes_total counter\n")
+	fmt.Fprintf(&b, "fcgi_response_bytes_total %d\n", atomic.LoadInt64(&m.bytesOut))
+	io.WriteString(w, b.String())
+}
+
+// summary is what expvar shows of the metrics.
+func (m *requestMetrics) summary() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byStatus := make(map[string]uint64)
+	for k, n := range m.requests {
+		byStatus[strconv.Itoa(k.status)] += n
+	}
+	return map[string]interface{}{
+		"requests":  m.count,
+		"by_status": byStatus,
+		"in_flight": atomic.LoadInt64(&inFlightCount),
+		"bytes_in":  atomic.LoadInt64(&m.bytesIn),
+		"bytes_out": atomic.LoadInt64(&m.bytesOut),
+	}
+}
+
+// adminHandler serves the metrics, the debug endpoints and the inspection
+// API, which are not for everyone that can reach the server.
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", serveMetrics)
+	handleInspect(mux)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// A middleware wraps a handler with some behaviour of its own.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws, the first one outermost.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// withRecovery turns a panic in h into a 500 carrying the request ID, and
+// logs it with the stack.
+func withRecovery(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			id := requestID(r)
+			log.Printf("%s: panic serving %s %s: %v\n%s", id, r.Method, r.URL, v, debug.Stack())
+			if rec.status == 0 {
+				http.Error(w, fmt.Sprintf("internal server error, request ID %s", id), http.StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// withBodyLimit answers 413 to requests whose body is larger than max bytes.
+// Bodies without a Content-Length are cut off at max, and whoever reads them
+// gets an *http.MaxBytesError. A max of 0 means no limit.
+func withBodyLimit(max int64) middleware {
+	return func(h http.Handler) http.Handler {
+		if max <= 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > max {
+				http.Error(w, fmt.Sprintf("request body larger than %d bytes", max), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withTimeout answers 503 to requests that take longer than d, including
+// reading the body. Handlers get a context that is done at the deadline; what
+// they write after it is dropped. The response is buffered until h returns.
+// A d of 0 means no deadline.
+func withTimeout(d time.Duration) middleware {
+	return func(h http.Handler) http.Handler {
+		if d <= 0 {
+			return h
+		}
+		return http.TimeoutHandler(h, d, fmt.Sprintf("request timed out after %v\n", d))
+	}
+}
+
+// compressWriter compresses a response once it knows, at the first write,
+// that the handler did not encode it itself.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string // "gzip" or "deflate"
+	method   string
+	started  bool
+	w        io.WriteCloser // nil if the response goes out as is
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if !cw.started {
+		cw.start(status)
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *compressWriter) start(status int) {
+	cw.started = true
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || cw.method == "HEAD" ||
+		status == http.StatusNoContent || status == http.StatusNotModified || status < 200 {
+		return
+	}
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	if cw.encoding == "gzip" {
+		cw.w = gzip.NewWriter(cw.ResponseWriter)
+	} else {
+		cw.w, _ = flate.NewWriter(cw.ResponseWriter, flate.DefaultCompression)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.w == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.w.Write(b)
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) close() {
+	if cw.w != nil {
+		cw.w.Close()
+	}
+}
+
+// withCompression compresses responses with gzip or deflate, whichever the
+// client prefers in Accept-Encoding.
+func withCompression(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, method: r.Method}
+		defer cw.close()
+		h.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks gzip or deflate by q-value, gzip on a tie, or ""
+// for neither.
+func acceptedEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, c := range parseQualities(header) {
+		if (c.value == "gzip" || c.value == "deflate") && c.q > 0 && (c.q > bestQ || c.q == bestQ && c.value == "gzip") {
+			best, bestQ = c.value, c.q
+		}
+	}
+	return best
+}
+
+// corsRule allows cross-origin requests from origins to paths starting
+// with prefix. An origin of "*" allows any.
+type corsRule struct {
+	prefix  string
+	origins []string
+}
+
+// parseCORS parses PREFIX=ORIGIN[,ORIGIN...], as in -cors /api/=https://example.com.
+func parseCORS(s string) (corsRule, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 || !strings.HasPrefix(s, "/") {
+		return corsRule{}, fmt.Errorf("CORS rule %q is not /PREFIX=ORIGIN[,ORIGIN...]", s)
+	}
+	rule := corsRule{prefix: s[:i]}
+	for _, o := range strings.Split(s[i+1:], ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			rule.origins = append(rule.origins, o)
+		}
+	}
+	if len(rule.origins) == 0 {
+		return corsRule{}, fmt.Errorf("CORS rule %q has no origins", s)
+	}
+	return rule, nil
+}
+
+func (rule *corsRule) allows(origin string) bool {
+	for _, o := range rule.origins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// withCORS answers preflight requests and adds the CORS headers for the
+// rule with the longest prefix matching the path.
+func withCORS(rules []corsRule) middleware {
+	return func(h http.Handler) http.Handler {
+		if len(rules) == 0 {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var rule *corsRule
+			for i := range rules {
+				if strings.HasPrefix(r.URL.Path, rules[i].prefix) && (rule == nil || len(rules[i].prefix) > len(rule.prefix)) {
+					rule = &rules[i]
+				}
+			}
+			origin := r.Header.Get("Origin")
+			if rule == nil || origin == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			if !rule.allows(origin) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS")
+				if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				}
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
+			h.ServeHTTP(w, r)
+		})
+	}
+}
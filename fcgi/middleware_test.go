@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecovery(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/after-header" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		panic("boom")
+	}), withRequestID, withRecovery)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "recover-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "recover-1") {
+		t.Errorf("got %d %q, want 500 with the request ID", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/after-header", nil))
+	if w.Code != http.StatusAccepted || w.Body.Len() != 0 {
+		t.Errorf("got %d %q after the header was sent, want it left alone", w.Code, w.Body.String())
+	}
+}
+
+func TestRecoveryLetsAbortThrough(t *testing.T) {
+	h := withRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func TestAcceptedEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                            "",
+		"gzip":                        "gzip",
+		"deflate, gzip":               "gzip",
+		"gzip;q=0.5, deflate":         "deflate",
+		"GZIP;q=0, deflate;q=0":       "",
+		"br, identity":                "",
+		" deflate ; q=0.8 , gzip;q=x": "gzip",
+	} {
+		if got := acceptedEncoding(header); got != want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestTimeoutCoversBodyReads(t *testing.T) {
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}), withTimeout(100*time.Millisecond))
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		for i := 0; i < 5; i++ {
+			if _, err := pw.Write([]byte("abc")); err != nil {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		pw.Close()
+	}()
+	w := httptest.NewRecorder()
+	start := time.Now()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", pr))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d %q for a body trickled past the deadline, want 503", w.Code, w.Body.String())
+	}
+	if d := time.Since(start); d > 400*time.Millisecond {
+		t.Errorf("answered after %v, want the deadline to cut the body read short", d)
+	}
+}
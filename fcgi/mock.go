@@ -0,0 +1,329 @@
+package main
+
+// Mock mode: with -mock FILE, requests matching a rule get its canned
+// response instead of the echo page. FILE holds a JSON array of rules:
+//
+//	[
+//	  {
+//	    "name": "get user",
+//	    "method": "GET",
+//	    "path": "/users/{id}",
+//	    "headers": {"Authorization": "Bearer *"},
+//	    "query": {"verbose": "1"},
+//	    "response": {
+//	      "status": 200,
+//	      "headers": {"Content-Type": "application/json"},
+//	      "body": "{\"id\": \"{{.Params.id}}\", \"agent\": \"{{.Headers.Get \"User-Agent\"}}\"}",
+//	      "delay": "250ms"
+//	    }
+//	  },
+//	  {"path": "/files/{rest...}", "response": {"file": "testdata/file.txt"}}
+//	]
+//
+// The first matching rule wins. A {name} path element matches one element,
+// {name...} the rest of the path. Header and query values are path.Match
+// patterns in which * and ? also match '/', so that "Bearer *" matches any
+// token; an empty one only requires the header or parameter to be there.
+// Bodies, inline or from a file relative to FILE, are text/template
+// templates of mockRequest. FILE and the body files are reloaded when they
+// change.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+var mocks *mockServer
+
+type mockRule struct {
+	Name     string            `json:"name"`
+	Method   string            `json:"method"` // any if empty
+	Path     string            `json:"path"`
+	Headers  map[string]string `json:"headers"`
+	Query    map[string]string `json:"query"`
+	Response mockResponse      `json:"response"`
+
+	segments []string
+	body     *template.Template
+}
+
+type mockResponse struct {
+	Status  int               `json:"status"` // 200 if 0
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	File    string            `json:"file"`
+	Delay   string            `json:"delay"` // e.g. "250ms"
+
+	delay time.Duration
+}
+
+// mockRequest is what response bodies are templates of.
+type mockRequest struct {
+	Method    string
+	Path      string
+	Params    map[string]string // from {name} path elements
+	Query     url.Values
+	Headers   http.Header
+	Body      string
+	RequestID string
+	Index     int64
+}
+
+type mockServer struct {
+	path  string
+	rules atomic.Value // []*mockRule
+	files map[string]time.Time
+}
+
+// newMockServer loads the rules in file, and reloads them every interval if
+// they or their body files changed.
+func newMockServer(file string, interval time.Duration) (*mockServer, error) {
+	m := &mockServer{path: file}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go m.watch(interval)
+	}
+	return m, nil
+}
+
+func (m *mockServer) load() error {
+	rules, files, err := loadMockRules(m.path)
+	if err != nil {
+		return err
+	}
+	m.rules.Store(rules)
+	m.files = files
+	return nil
+}
+
+func (m *mockServer) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		changed := false
+		for file, mtime := range statFiles(m.files) {
+			if !mtime.Equal(m.files[file]) {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := m.load(); err != nil {
+			log.Printf("mock: keeping the previous rules: %v", err)
+			m.files = statFiles(m.files) // do not report the same error every tick
+			continue
+		}
+		log.Printf("mock: reloaded %s", m.path)
+	}
+}
+
+func statFiles(files map[string]time.Time) map[string]time.Time {
+	stat := make(map[string]time.Time, len(files))
+	for file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			stat[file] = fi.ModTime()
+		} else {
+			stat[file] = time.Time{}
+		}
+	}
+	return stat
+}
+
+// loadMockRules returns the rules in file, and the modification times of
+// file and the body files.
+func loadMockRules(file string) ([]*mockRule, map[string]time.Time, error) {
+	files := map[string]time.Time{file: {}}
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rules []*mockRule
+	dec := json.NewDecoder(bytes.NewReader(src))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rules); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for i, rule := range rules {
+		where := fmt.Sprintf("%s: rule %d", file, i+1)
+		if rule.Name != "" {
+			where += " (" + rule.Name + ")"
+		}
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, nil, fmt.Errorf("%s: path %q does not start with /", where, rule.Path)
+		}
+		rule.segments = strings.Split(rule.Path, "/")[1:]
+		for j, seg := range rule.segments {
+			if strings.HasSuffix(seg, "...}") && j != len(rule.segments)-1 {
+				return nil, nil, fmt.Errorf("%s: %s must be the last path element", where, seg)
+			}
+		}
+		for name, pattern := range rule.Headers {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, nil, fmt.Errorf("%s: header %s: %v", where, name, err)
+			}
+		}
+		for name, pattern := range rule.Query {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, nil, fmt.Errorf("%s: query %s: %v", where, name, err)
+			}
+		}
+
+		resp := &rule.Response
+		if resp.Status == 0 {
+			resp.Status = http.StatusOK
+		}
+		if resp.Status < 100 || resp.Status > 599 {
+			return nil, nil, fmt.Errorf("%s: status %d", where, resp.Status)
+		}
+		if resp.Delay != "" {
+			if resp.delay, err = time.ParseDuration(resp.Delay); err != nil {
+				return nil, nil, fmt.Errorf("%s: delay: %v", where, err)
+			}
+		}
+		body := resp.Body
+		if resp.File != "" {
+			if resp.Body != "" {
+				return nil, nil, fmt.Errorf("%s: both body and file", where)
+			}
+			bodyFile := resp.File
+			if !filepath.IsAbs(bodyFile) {
+				bodyFile = filepath.Join(filepath.Dir(file), bodyFile)
+			}
+			files[bodyFile] = time.Time{}
+			b, err := ioutil.ReadFile(bodyFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %v", where, err)
+			}
+			body = string(b)
+		}
+		if rule.body, err = template.New(where).Option("missingkey=zero").Parse(body); err != nil {
+			return nil, nil, err
+		}
+	}
+	return rules, statFiles(files), nil
+}
+
+// match returns the path parameters if r matches the rule.
+func (rule *mockRule) match(r *http.Request) (map[string]string, bool) {
+	if rule.Method != "" && !strings.EqualFold(rule.Method, r.Method) {
+		return nil, false
+	}
+	params := make(map[string]string)
+	segs := strings.Split(r.URL.Path, "/")[1:]
+	for i, want := range rule.segments {
+		if strings.HasPrefix(want, "{") && strings.HasSuffix(want, "...}") {
+			params[want[1:len(want)-4]] = strings.Join(segs[i:], "/")
+			break
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		if strings.HasPrefix(want, "{") && strings.HasSuffix(want, "}") {
+			params[want[1:len(want)-1]] = segs[i]
+		} else if want != segs[i] {
+			return nil, false
+		}
+		if i == len(rule.segments)-1 && len(segs) > len(rule.segments) {
+			return nil, false
+		}
+	}
+	for name, pattern := range rule.Headers {
+		if !matchAny(pattern, r.Header[http.CanonicalHeaderKey(name)]) {
+			return nil, false
+		}
+	}
+	q := r.URL.Query()
+	for name, pattern := range rule.Query {
+		if !matchAny(pattern, q[name]) {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// matchAny reports whether one of values matches pattern, or if pattern is
+// empty whether there are values at all.
+func matchAny(pattern string, values []string) bool {
+	for _, v := range values {
+		if pattern == "" || matchValue(pattern, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchValue is path.Match without the special meaning of '/': both sides
+// have it replaced by a byte that header values cannot hold.
+func matchValue(pattern, value string) bool {
+	ok, _ := path.Match(strings.Replace(pattern, "/", "\x00", -1), strings.Replace(value, "/", "\x00", -1))
+	return ok
+}
+
+// withMock answers requests matching a mock rule, and hands the others to h.
+func withMock(h http.Handler) http.Handler {
+	if mocks == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rules, _ := mocks.rules.Load().([]*mockRule)
+		for i, rule := range rules {
+			if params, ok := rule.match(r); ok {
+				serveMock(w, r, i, rule, params)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func serveMock(w http.ResponseWriter, r *http.Request, i int, rule *mockRule, params map[string]string) {
+	body, _ := ioutil.ReadAll(r.Body)
+	data := mockRequest{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Params:    params,
+		Query:     r.URL.Query(),
+		Headers:   r.Header,
+		Body:      string(body),
+		RequestID: requestID(r),
+		Index:     requestIndex(r),
+	}
+	var out bytes.Buffer
+	if err := rule.body.Execute(&out, data); err != nil {
+		http.Error(w, fmt.Sprintf("mock rule %d: %v", i+1, err), http.StatusInternalServerError)
+		return
+	}
+
+	if d := rule.Response.delay; d > 0 {
+		select {
+		case <-time.After(d):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	name := rule.Name
+	if name == "" {
+		name = strconv.Itoa(i + 1)
+	}
+	w.Header().Set("X-Mock-Rule", name)
+	for k, v := range rule.Response.Headers {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(rule.Response.Status)
+	w.Write(out.Bytes())
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const mockRulesJSON = `[
+  {"name": "user", "method": "GET", "path": "/users/{id}"},
+  {"name": "repo", "path": "/repos/{owner}/{repo}/tree/{rest...}"},
+  {"name": "auth", "path": "/private", "headers": {"Authorization": "Bearer *"}},
+  {"name": "trace", "path": "/trace", "headers": {"X-Trace": ""}},
+  {"name": "search", "path": "/search", "query": {"q": "go*", "debug": ""}}
+]`
+
+func writeMockRules(t *testing.T, file, rules string) {
+	if err := ioutil.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMockRuleMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcgi-mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.json")
+	writeMockRules(t, file, mockRulesJSON)
+	rules, _, err := loadMockRules(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, url string
+		header      map[string]string
+		rule        string // name of the first matching rule, "" for none
+		params      map[string]string
+	}{
+		{"GET", "/users/42", nil, "user", map[string]string{"id": "42"}},
+		{"get", "/users/42", nil, "user", map[string]string{"id": "42"}},
+		{"POST", "/users/42", nil, "", nil},
+		{"GET", "/users", nil, "", nil},
+		{"GET", "/users/42/posts", nil, "", nil},
+		{"GET", "/repos/golang/go/tree/src/net/http", nil, "repo",
+			map[string]string{"owner": "golang", "repo": "go", "rest": "src/net/http"}},
+		{"GET", "/repos/golang/go/tree/", nil, "repo",
+			map[string]string{"owner": "golang", "repo": "go", "rest": ""}},
+		{"GET", "/repos/golang/go/blob/main", nil, "", nil},
+		{"GET", "/private", map[string]string{"authorization": "Bearer abc/def+=="}, "auth", map[string]string{}},
+		{"GET", "/private", map[string]string{"Authorization": "Basic abc"}, "", nil},
+		{"GET", "/private", nil, "", nil},
+		{"GET", "/trace", map[string]string{"X-Trace": ""}, "trace", map[string]string{}},
+		{"GET", "/trace", nil, "", nil},
+		{"GET", "/search?q=golang/go&debug", nil, "search", map[string]string{}},
+		{"GET", "/search?q=rust&debug=1", nil, "", nil},
+		{"GET", "/search?q=go", nil, "", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.url, nil)
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		got, params := "", map[string]string(nil)
+		for _, rule := range rules {
+			if p, ok := rule.match(r); ok {
+				got, params = rule.Name, p
+				break
+			}
+		}
+		if got != tt.rule || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("%s %s %v: matched %q %v, want %q %v", tt.method, tt.url, tt.header, got, params, tt.rule, tt.params)
+		}
+	}
+}
+
+func TestMockRulesReload(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	dir, err := ioutil.TempDir("", "fcgi-mock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.json")
+	writeMockRules(t, file, `[{"name": "old", "path": "/a"}]`)
+	m, err := newMockServer(file, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := func() string {
+		return m.rules.Load().([]*mockRule)[0].Name
+	}
+	// update rewrites the rules with a later modification time, however
+	// coarse the file system clock is, and waits for the next reload.
+	mtime := time.Now()
+	update := func(rules string) {
+		writeMockRules(t, file, rules)
+		mtime = mtime.Add(time.Second)
+		os.Chtimes(file, mtime, mtime)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	update(`[{"name": "new", "path": "/a"}]`)
+	if got := name(); got != "new" {
+		t.Fatalf("after a change: rule %q, want new", got)
+	}
+	update(`[{"name": "broken", "path": "a"}]`)
+	if got := name(); got != "new" {
+		t.Errorf("after an invalid change: rule %q, want the previous rules kept", got)
+	}
+	update(`[{"name": "fixed", "path": "/a"}]`)
+	if got := name(); got != "fixed" {
+		t.Errorf("after fixing the file: rule %q, want fixed", got)
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+// Proxy mode: with -upstream URL, requests that no mock rule answers are
+// forwarded to URL instead of getting the echo page. With -record FILE, each
+// request is written to FILE with the upstream response, as a HAR file if
+// FILE ends in .har and as JSON lines otherwise:
+//
+//	{"request": {"method": "GET", "url": "/users/1", ...}, "response": {"status": 200, ...}, "duration_ms": 12.5}
+//
+// A HAR file is written when the server stops, with the exchanges kept in
+// memory until then, so it suits short sessions; JSON lines are written as
+// the exchanges happen.
+//
+// With -replay FILE, requests matching a recording in FILE get the recorded
+// response instead, without contacting the upstream, which can then be left
+// out to run offline. A request matches on its method, path, query and body.
+// When the same request was recorded several times, the responses are
+// served in the order they were recorded, the last one again and again.
+//
+// Responses are read whole before being sent on when recording, so that
+// streams are only recorded once they end.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var proxy *recordingProxy
+
+// exchange is a recorded request and the upstream response to it.
+type exchange struct {
+	Request  *captured         `json:"request"`
+	Response *recordedResponse `json:"response"`
+	Duration float64           `json:"duration_ms"`
+}
+
+type recordedResponse struct {
+	Status       int                 `json:"status"`
+	Proto        string              `json:"proto"`
+	Headers      map[string][]string `json:"headers"`
+	Body         string              `json:"body"`
+	BodyEncoding string              `json:"body_encoding"` // "text", or "base64" if the body is not UTF-8
+}
+
+func newRecordedResponse(resp *http.Response, body []byte) *recordedResponse {
+	rr := &recordedResponse{
+		Status:  resp.StatusCode,
+		Proto:   resp.Proto,
+		Headers: resp.Header,
+	}
+	rr.Body, rr.BodyEncoding = encodeBody(body)
+	return rr
+}
+
+func (rr *recordedResponse) body() ([]byte, error) {
+	return decodeBody(rr.Body, rr.BodyEncoding)
+}
+
+type exchangeKey struct{}
+
+// recordingProxy forwards requests to upstream, recording them in recorder,
+// unless playback has a recording of them. upstream and recorder may be
+// nil.
+type recordingProxy struct {
+	upstream *url.URL
+	proxy    *httputil.ReverseProxy
+	recorder *recorder
+	playback *playback
+}
+
+func newRecordingProxy(upstream, recordFile, replayFile string) (*recordingProxy, error) {
+	p := &recordingProxy{}
+	if upstream != "" {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("upstream %q is not an http or https URL", upstream)
+		}
+		p.upstream = u
+		p.proxy = &httputil.ReverseProxy{
+			Rewrite:        p.rewrite,
+			ModifyResponse: p.record,
+			ErrorHandler:   proxyError,
+		}
+	}
+	if recordFile != "" {
+		if p.upstream == nil {
+			return nil, fmt.Errorf("nothing to record in %s without an upstream", recordFile)
+		}
+		var err error
+		if p.recorder, err = newRecorder(recordFile); err != nil {
+			return nil, err
+		}
+	}
+	if replayFile != "" {
+		var err error
+		if p.playback, err = loadPlayback(replayFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *recordingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n, x := p.playback.find(r, body); x != nil {
+		serveRecording(w, n, x)
+		return
+	}
+	if p.proxy == nil {
+		http.Error(w, fmt.Sprintf("no recording of %s %s", r.Method, r.URL.RequestURI()), http.StatusBadGateway)
+		return
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if p.recorder != nil {
+		x := &exchange{Request: newCaptured(r, body, requestIndex(r))}
+		r = r.WithContext(context.WithValue(r.Context(), exchangeKey{}, x))
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+// rewrite sends the request to the upstream, asking for an uncompressed
+// response so that recordings are readable; withCompression compresses it
+// again for the client.
+func (p *recordingProxy) rewrite(pr *httputil.ProxyRequest) {
+	pr.SetURL(p.upstream)
+	pr.SetXForwarded()
+	pr.Out.Header.Del("Accept-Encoding")
+}
+
+// record reads the upstream response and records it with the request.
+func (p *recordingProxy) record(resp *http.Response) error {
+	x, _ := resp.Request.Context().Value(exchangeKey{}).(*exchange)
+	if x == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	x.Response = newRecordedResponse(resp, body)
+	x.Duration = float64(time.Since(x.Request.Time)) / float64(time.Millisecond)
+	if err := p.recorder.add(x); err != nil {
+		log.Printf("record: %v", err)
+	}
+	return nil
+}
+
+// close closes the record file. A nil proxy has none.
+func (p *recordingProxy) close() error {
+	if p == nil {
+		return nil
+	}
+	return p.recorder.close()
+}
+
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("%s: proxy %s %s: %v", requestID(r), r.Method, r.URL, err)
+	http.Error(w, fmt.Sprintf("upstream: %v", err), http.StatusBadGateway)
+}
+
+// serveRecording sends the recorded response of x, the nth recording.
+func serveRecording(w http.ResponseWriter, n int, x *exchange) {
+	body, err := x.Response.body()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("recording %d: %v", n, err), http.StatusInternalServerError)
+		return
+	}
+	for k, v := range x.Response.Headers {
+		w.Header()[k] = v
+	}
+	for _, name := range hopHeaders {
+		w.Header().Del(name)
+	}
+	w.Header().Set("X-Recording", strconv.Itoa(n))
+	w.WriteHeader(x.Response.Status)
+	w.Write(body)
+}
+
+// recorder writes exchanges to a file as JSON lines, or keeps them to write
+// a HAR file on close. A nil recorder records nothing.
+type recorder struct {
+	mu   sync.Mutex
+	path string
+	file *os.File   // JSON lines
+	har  []harEntry // HAR, with the entries already in the file
+}
+
+func newRecorder(path string) (*recorder, error) {
+	rec := &recorder{path: path}
+	if !isHAR(path) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		rec.file = f
+		return rec, nil
+	}
+	rec.har = []harEntry{}
+	if _, err := os.Stat(path); err == nil {
+		xs, err := readRecordings(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range xs {
+			rec.har = append(rec.har, newHAREntry(x))
+		}
+	}
+	return rec, nil
+}
+
+func (rec *recorder) add(x *exchange) error {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.file != nil {
+		b, err := json.Marshal(x)
+		if err != nil {
+			return err
+		}
+		_, err = rec.file.Write(append(b, '\n'))
+		return err
+	}
+	if rec.har == nil {
+		return fmt.Errorf("%s is closed", rec.path)
+	}
+	rec.har = append(rec.har, newHAREntry(x))
+	return nil
+}
+
+// writeHAR replaces the file by way of a temporary one, so that it is never
+// seen half written.
+func (rec *recorder) writeHAR() error {
+	var doc harFile
+	doc.Log.Version = "1.2"
+	doc.Log.Creator.Name = "fcgi"
+	doc.Log.Creator.Version = "1"
+	doc.Log.Entries = rec.har
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := rec.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rec.path)
+}
+
+// close closes the JSON lines file, or writes the HAR file.
+func (rec *recorder) close() error {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.file != nil {
+		err := rec.file.Close()
+		rec.file = nil
+		return err
+	}
+	if rec.har == nil {
+		return nil
+	}
+	err := rec.writeHAR()
+	rec.har = nil
+	return err
+}
+
+func isHAR(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".har")
+}
+
+// readRecordings reads a file written by a recorder.
+func readRecordings(path string) ([]*exchange, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var xs []*exchange
+	if isHAR(path) {
+		var doc harFile
+		if err := json.NewDecoder(f).Decode(&doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for i, e := range doc.Log.Entries {
+			x, err := e.exchange()
+			if err != nil {
+				return nil, fmt.Errorf("%s: entry %d: %v", path, i+1, err)
+			}
+			xs = append(xs, x)
+		}
+		return xs, nil
+	}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var x exchange
+		if err := json.Unmarshal(sc.Bytes(), &x); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if x.Request == nil || x.Response == nil {
+			return nil, fmt.Errorf("%s:%d: no request or no response", path, line)
+		}
+		xs = append(xs, &x)
+	}
+	return xs, sc.Err()
+}
+
+// playbackKey is what a request must have in common with a recording to
+// be answered with it.
+type playbackKey struct {
+	method, path, query, body string
+}
+
+func newPlaybackKey(method string, u *url.URL, body []byte) playbackKey {
+	// Encode sorts the parameters, so that their order does not matter.
+	return playbackKey{method, u.Path, u.Query().Encode(), string(body)}
+}
+
+// playback serves recordings back. A nil playback has none.
+type playback struct {
+	mu       sync.Mutex
+	byKey    map[playbackKey][]int // indexes in recorded
+	served   map[playbackKey]int
+	recorded []*exchange
+}
+
+func loadPlayback(path string) (*playback, error) {
+	xs, err := readRecordings(path)
+	if err != nil {
+		return nil, err
+	}
+	pb := &playback{byKey: make(map[playbackKey][]int), served: make(map[playbackKey]int), recorded: xs}
+	for i, x := range xs {
+		u, err := url.ParseRequestURI(x.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: recording %d: %v", path, i+1, err)
+		}
+		body, err := x.Request.body()
+		if err != nil {
+			return nil, fmt.Errorf("%s: recording %d: %v", path, i+1, err)
+		}
+		k := newPlaybackKey(x.Request.Method, u, body)
+		pb.byKey[k] = append(pb.byKey[k], i)
+	}
+	log.Printf("replay: %d recordings of %d requests from %s", len(xs), len(pb.byKey), path)
+	return pb, nil
+}
+
+// find returns the recording to answer r with and its number from 1, or
+// nil if there is none.
+func (pb *playback) find(r *http.Request, body []byte) (int, *exchange) {
+	if pb == nil {
+		return 0, nil
+	}
+	k := newPlaybackKey(r.Method, r.URL, body)
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	indexes := pb.byKey[k]
+	if len(indexes) == 0 {
+		return 0, nil
+	}
+	n := pb.served[k]
+	if n < len(indexes)-1 {
+		pb.served[k]++
+	}
+	i := indexes[n]
+	return i + 1, pb.recorded[i]
+}
+
+// The subset of HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/)
+// that recordings need. Fields starting with _ are ours.
+type harFile struct {
+	Log struct {
+		Version string `json:"version"`
+		Creator struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	} `json:"timings"`
+	RequestID  string `json:"_requestId,omitempty"`
+	RemoteAddr string `json:"_remoteAddr,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []interface{}  `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"` // "base64", not in HAR 1.2 for requests
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []interface{}  `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Encoding string `json:"encoding,omitempty"`
+	} `json:"content"`
+	RedirectURL string `json:"redirectURL"`
+	HeadersSize int    `json:"headersSize"`
+	BodySize    int    `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func harHeaders(h map[string][]string) []harNameValue {
+	nvs := []harNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			nvs = append(nvs, harNameValue{name, v})
+		}
+	}
+	return nvs
+}
+
+func fromHARHeaders(nvs []harNameValue) http.Header {
+	h := make(http.Header)
+	for _, nv := range nvs {
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
+
+func newHAREntry(x *exchange) harEntry {
+	req, resp := x.Request, x.Response
+	var e harEntry
+	e.StartedDateTime = req.Time
+	e.Time = x.Duration
+	e.Timings.Wait = x.Duration
+	e.RequestID = req.ID
+	e.RemoteAddr = req.RemoteAddr
+
+	reqBody, _ := req.body()
+	e.Request = harRequest{
+		Method:      req.Method,
+		URL:         "http://" + req.Host + req.URL,
+		HTTPVersion: req.Proto,
+		Cookies:     []interface{}{},
+		Headers:     harHeaders(req.Headers),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(reqBody),
+	}
+	if u, err := url.ParseRequestURI(req.URL); err == nil {
+		for name, values := range u.Query() {
+			for _, v := range values {
+				e.Request.QueryString = append(e.Request.QueryString, harNameValue{name, v})
+			}
+		}
+	}
+	if len(reqBody) > 0 {
+		e.Request.PostData = &harPostData{MimeType: http.Header(req.Headers).Get("Content-Type"), Text: req.Body}
+		if req.BodyEncoding == "base64" {
+			e.Request.PostData.Encoding = "base64"
+		}
+	}
+
+	respBody, _ := resp.body()
+	headers := http.Header(resp.Headers)
+	e.Response = harResponse{
+		Status:      resp.Status,
+		StatusText:  http.StatusText(resp.Status),
+		HTTPVersion: resp.Proto,
+		Cookies:     []interface{}{},
+		Headers:     harHeaders(resp.Headers),
+		RedirectURL: headers.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(respBody),
+	}
+	e.Response.Content.Size = len(respBody)
+	e.Response.Content.MimeType = headers.Get("Content-Type")
+	e.Response.Content.Text = resp.Body
+	if resp.BodyEncoding == "base64" {
+		e.Response.Content.Encoding = "base64"
+	}
+	return e
+}
+
+// exchange converts back an entry, which may come from another tool.
+func (e *harEntry) exchange() (*exchange, error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	req := &captured{
+		Time:         e.StartedDateTime,
+		ID:           e.RequestID,
+		Method:       e.Request.Method,
+		URL:          u.RequestURI(),
+		Proto:        e.Request.HTTPVersion,
+		Host:         u.Host,
+		RemoteAddr:   e.RemoteAddr,
+		Headers:      fromHARHeaders(e.Request.Headers),
+		BodyEncoding: "text",
+	}
+	if pd := e.Request.PostData; pd != nil {
+		req.Body = pd.Text
+		if pd.Encoding == "base64" {
+			req.BodyEncoding = "base64"
+		}
+	}
+	resp := &recordedResponse{
+		Status:       e.Response.Status,
+		Proto:        e.Response.HTTPVersion,
+		Headers:      fromHARHeaders(e.Response.Headers),
+		Body:         e.Response.Content.Text,
+		BodyEncoding: "text",
+	}
+	if e.Response.Content.Encoding == "base64" {
+		resp.BodyEncoding = "base64"
+	}
+	return &exchange{Request: req, Response: resp, Duration: e.Time}, nil
+}
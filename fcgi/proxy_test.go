@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecorderRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fcgi-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	x := &exchange{
+		Request: &captured{
+			Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), ID: "rec-1", Method: "POST",
+			URL: "/users?a=1", Proto: "HTTP/1.1", Host: "example.com", RemoteAddr: "127.0.0.1:1234",
+			Headers: map[string][]string{"Content-Type": {"application/octet-stream"}},
+			Body:    "/wAB", BodyEncoding: "base64",
+		},
+		Response: &recordedResponse{
+			Status: 201, Proto: "HTTP/1.1",
+			Headers: map[string][]string{"Content-Type": {"application/json"}},
+			Body:    `{"id":1}`, BodyEncoding: "text",
+		},
+		Duration: 1.5,
+	}
+	for _, name := range []string{"rec.jsonl", "rec.har"} {
+		path := filepath.Join(dir, name)
+		rec, err := newRecorder(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if err := rec.add(x); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rec.close(); err != nil {
+			t.Fatal(err)
+		}
+		xs, err := readRecordings(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(xs) != 2 || !reflect.DeepEqual(xs[1], x) {
+			t.Errorf("%s: read %d recordings, the last %+v %+v; want 2 of %+v %+v",
+				name, len(xs), xs[len(xs)-1].Request, xs[len(xs)-1].Response, x.Request, x.Response)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+// The replay command re-sends the requests of a capture file, in order:
+//
+//	go run . replay -target http://localhost:8080 [-timing] [-speed 2] requests.jsonl
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// hopHeaders belong to the original connection, not to the request.
+var hopHeaders = []string{"Connection", "Content-Length", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+func replayMain(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL to send the requests to")
+	timing := fs.Bool("timing", false, "wait between requests as long as the original client did")
+	speed := fs.Float64("speed", 1, "with -timing, replay this many times faster")
+	keepHost := fs.Bool("host", false, "send the original Host header instead of the target's")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: fcgi replay [-target URL] [-timing] [-speed N] [-host] FILE")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	failed := 0
+	var last time.Time
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if strings.TrimSpace(sc.Text()) == "" {
+			continue
+		}
+		var c captured
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %v\n", fs.Arg(0), line, err)
+			failed++
+			continue
+		}
+		if *timing && !last.IsZero() && c.Time.After(last) {
+			time.Sleep(time.Duration(float64(c.Time.Sub(last)) / *speed))
+		}
+		last = c.Time
+
+		status, err := replay(*target, &c, *keepHost)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:%d: %s %s: %v\n", fs.Arg(0), line, c.Method, c.URL, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s %s %s -> %s\n", c.ID, c.Method, c.URL, status)
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// replay sends c to target and returns the response status.
+func replay(target string, c *captured, keepHost bool) (string, error) {
+	body, err := c.body()
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(c.Method, strings.TrimSuffix(target, "/")+c.URL, strings.NewReader(string(body)))
+	if err != nil {
+		return "", err
+	}
+	for name, values := range c.Headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	for _, name := range hopHeaders {
+		req.Header.Del(name)
+	}
+	if keepHost {
+		req.Host = c.Host
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.Status, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+)
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an ID: the incoming X-Request-ID if it
+// is sensible, or else a random one. It is sent back in X-Request-ID and
+// written to the access log.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestID returns the ID given to r by withRequestID, or "".
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Print(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts up to 128 printable ASCII characters, so a client
+// ID can be logged and echoed without escaping.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package main
+
+// On SIGINT or SIGTERM the server stops accepting connections, lets the
+// requests in flight finish for up to -shutdown-timeout, closes the capture
+// file and access log and exits: with status 0 if every request finished, 1
+// if some had to be cut off.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	inFlight      sync.WaitGroup
+	inFlightCount int64 // requests being served, updated atomically
+
+	// stopping is closed when the server starts shutting down, to end
+	// requests that would otherwise never finish, like event streams.
+	stopping     = make(chan struct{})
+	stoppingOnce sync.Once
+
+	// drainMu orders the start of requests and the closing of stopping, so
+	// that no request is added to inFlight once shutdown waits for it.
+	drainMu sync.RWMutex
+)
+
+// withDrain keeps count of the requests being served. Once the server is
+// stopping, requests still arriving on open connections, as FastCGI ones
+// do, get a 503 and the connection is closed.
+func withDrain(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		drainMu.RLock()
+		select {
+		case <-stopping:
+			drainMu.RUnlock()
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		inFlight.Add(1)
+		drainMu.RUnlock()
+		atomic.AddInt64(&inFlightCount, 1)
+		defer func() {
+			atomic.AddInt64(&inFlightCount, -1)
+			inFlight.Done()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveUntilSignal runs the servers until one fails or a signal asks to
+// stop, then shuts them all down and exits.
+func serveUntilSignal(servers []server, timeout time.Duration) {
+	errc := make(chan error, len(servers))
+	for _, s := range servers {
+		s := s
+		log.Printf("serving %s", s.name)
+		go func() {
+			if err := s.serve(); err != nil {
+				errc <- fmt.Errorf("%s: %v", s.name, err)
+			}
+		}()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errc:
+		captures.close()
+		proxy.close()
+		log.Fatal(err)
+	case sig := <-sigs:
+		log.Printf("%v: shutting down, %d requests in flight", sig, atomic.LoadInt64(&inFlightCount))
+		signal.Stop(sigs)
+	}
+	os.Exit(shutdown(servers, timeout))
+}
+
+// shutdown stops accepting requests and waits up to timeout for the ones in
+// flight. It returns the exit status.
+func shutdown(servers []server, timeout time.Duration) int {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stoppingOnce.Do(func() {
+		drainMu.Lock()
+		close(stopping)
+		drainMu.Unlock()
+	})
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	status := 0
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s server) {
+			defer wg.Done()
+			if err := s.stop(ctx); err != nil && err != context.DeadlineExceeded {
+				log.Printf("shutdown: %s: %v", s.name, err)
+				mu.Lock()
+				status = 1
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	drained := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		log.Print("shutdown: all requests finished")
+	case <-ctx.Done():
+		log.Printf("shutdown: gave up after %v with %d requests in flight", timeout, atomic.LoadInt64(&inFlightCount))
+		status = 1
+	}
+	if err := captures.close(); err != nil {
+		log.Printf("shutdown: capture file: %v", err)
+		status = 1
+	}
+	if err := proxy.close(); err != nil {
+		log.Printf("shutdown: record file: %v", err)
+		status = 1
+	}
+	if err := accessLog.close(); err != nil {
+		log.Printf("shutdown: access log: %v", err)
+		status = 1
+	}
+	return status
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDrainRefusesRequestsWhenStopping(t *testing.T) {
+	served := int32(0)
+	h := withDrain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK || served != 1 {
+		t.Fatalf("before stopping: status %d, %d served", w.Code, served)
+	}
+
+	saved := stopping
+	stopping = make(chan struct{})
+	close(stopping)
+	defer func() { stopping = saved }()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Connection") != "close" || served != 1 {
+		t.Errorf("when stopping: status %d, Connection %q, %d served; want 503, close and 1",
+			w.Code, w.Header().Get("Connection"), served)
+	}
+	inFlight.Wait() // must not wait for the refused request
+}
@@ -0,0 +1,754 @@
+package main
+
+// Codec generation: with -codec, every generated struct gets MarshalJSON and
+// UnmarshalJSON methods that encode and decode its fields directly, with the
+// small scanner in codecRuntime instead of encoding/json reflection. Types
+// the generator does not know about, such as overridden ones, still go
+// through encoding/json.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+)
+
+func (g *generator) codecMethods() string {
+	structs := make(map[string]bool)
+	for _, s := range g.structs {
+		structs[s.name] = true
+	}
+	c := &codecWriter{structs: structs}
+	for _, s := range g.structs {
+		c.marshal(s)
+		c.unmarshal(s)
+	}
+	return c.b.String()
+}
+
+// codecWriter writes the methods. Indentation is left to go/format.
+type codecWriter struct {
+	b       bytes.Buffer
+	structs map[string]bool
+}
+
+func (c *codecWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&c.b, format+"\n", args...)
+}
+
+// jsonName returns the key and omitempty option of a json tag, and false if
+// the field is not encoded.
+func jsonName(f fieldInfo) (string, bool, bool) {
+	parts := strings.Split(f.jsonTag, ",")
+	if parts[0] == "-" && len(parts) == 1 {
+		return "", false, false
+	}
+	key := parts[0]
+	if key == "" {
+		key = f.name
+	}
+	omitempty := false
+	for _, p := range parts[1:] {
+		omitempty = omitempty || p == "omitempty"
+	}
+	return key, omitempty, true
+}
+
+func (c *codecWriter) marshal(s structInfo) {
+	var body bytes.Buffer
+	inner := &codecWriter{structs: c.structs}
+	needErr := false
+	for _, f := range s.fields {
+		key, omitempty, ok := jsonName(f)
+		if !ok {
+			continue
+		}
+		expr := "v." + f.name
+		cond := ""
+		if omitempty {
+			cond = nonEmpty(f.typ, expr)
+		}
+		if cond != "" {
+			inner.printf("if %s {", cond)
+		}
+		k, _ := json.Marshal(key)
+		inner.printf("b = append(b, %s...)", strconv.Quote(","+string(k)+":"))
+		needErr = inner.encode(f.typ, expr, 0) || needErr
+		if cond != "" {
+			inner.printf("}")
+		}
+	}
+	body.Write(inner.b.Bytes())
+
+	c.printf("// MarshalJSON encodes v without reflection.")
+	c.printf("func (v %s) MarshalJSON() ([]byte, error) {", s.name)
+	c.printf("return v.appendJSON(make([]byte, 0, 512))")
+	c.printf("}\n")
+	c.printf("func (v *%s) appendJSON(b []byte) ([]byte, error) {", s.name)
+	if needErr {
+		c.printf("var err error")
+	}
+	// Every member is written with a leading comma; the first one becomes
+	// the opening brace.
+	c.printf("start := len(b)")
+	c.b.Write(body.Bytes())
+	c.printf("if len(b) == start {")
+	c.printf("return append(b, \"{}\"...), nil")
+	c.printf("}")
+	c.printf("b[start] = '{'")
+	c.printf("return append(b, '}'), nil")
+	c.printf("}\n")
+}
+
+// nonEmpty returns the condition under which omitempty keeps expr, or ""
+// if it is always kept.
+func nonEmpty(typ, expr string) string {
+	switch {
+	case typ == "string" || typ == "json.Number":
+		return expr + " != \"\""
+	case typ == "bool":
+		return expr
+	case typ == "int" || typ == "int64" || typ == "uint64" || typ == "float64":
+		return expr + " != 0"
+	case typ == "interface{}":
+		return expr + " != nil"
+	case strings.HasPrefix(typ, "[]"):
+		return "len(" + expr + ") != 0"
+	}
+	return ""
+}
+
+// encode writes the statements appending expr, of type typ, to b. It reports
+// whether they use err.
+func (c *codecWriter) encode(typ, expr string, depth int) bool {
+	switch typ {
+	case "string":
+		c.printf("b = jsonAppendString(b, %s)", expr)
+	case "bool":
+		c.printf("b = jsonAppendBool(b, %s)", expr)
+	case "int", "int64":
+		c.printf("b = jsonAppendInt(b, int64(%s))", expr)
+	case "uint64":
+		c.printf("b = jsonAppendUint(b, %s)", expr)
+	case "json.Number":
+		c.printf("b = jsonAppendNumber(b, %s)", expr)
+	case "float64":
+		c.printf("if b, err = jsonAppendFloat(b, %s); err != nil {", expr)
+		c.printf("return b, err")
+		c.printf("}")
+		return true
+	default:
+		if strings.HasPrefix(typ, "[]") {
+			i := "i" + strconv.Itoa(depth)
+			c.printf("if %s == nil {", expr)
+			c.printf("b = append(b, \"null\"...)")
+			c.printf("} else {")
+			c.printf("b = append(b, '[')")
+			c.printf("for %s := range %s {", i, expr)
+			c.printf("if %s > 0 {", i)
+			c.printf("b = append(b, ',')")
+			c.printf("}")
+			needErr := c.encode(typ[2:], expr+"["+i+"]", depth+1)
+			c.printf("}")
+			c.printf("b = append(b, ']')")
+			c.printf("}")
+			return needErr
+		}
+		if c.structs[typ] {
+			c.printf("if b, err = %s.appendJSON(b); err != nil {", expr)
+		} else {
+			c.printf("if b, err = jsonAppendMarshal(b, %s); err != nil {", expr)
+		}
+		c.printf("return b, err")
+		c.printf("}")
+		return true
+	}
+	return false
+}
+
+func (c *codecWriter) unmarshal(s structInfo) {
+	c.printf("// UnmarshalJSON decodes data into v without reflection.")
+	c.printf("func (v *%s) UnmarshalJSON(data []byte) error {", s.name)
+	c.printf("s := jsonScanner{data: data}")
+	c.printf("if err := v.decodeJSON(&s); err != nil {")
+	c.printf("return err")
+	c.printf("}")
+	c.printf("return s.end()")
+	c.printf("}\n")
+	c.printf("func (v *%s) decodeJSON(s *jsonScanner) error {", s.name)
+	c.printf("return s.object(func(key string) error {")
+	c.printf("switch key {")
+	for _, f := range s.fields {
+		key, _, ok := jsonName(f)
+		if !ok {
+			continue
+		}
+		c.printf("case %s:", strconv.Quote(key))
+		c.decode(f.typ, "v."+f.name, 0)
+	}
+	c.printf("}")
+	c.printf("return s.skipValue()")
+	c.printf("})")
+	c.printf("}\n")
+}
+
+// decode writes the statements decoding the next value into target, of type
+// typ, and returning the error.
+func (c *codecWriter) decode(typ, target string, depth int) {
+	switch typ {
+	case "string":
+		c.printf("return s.decodeString(&%s)", target)
+	case "bool":
+		c.printf("return s.decodeBool(&%s)", target)
+	case "int":
+		c.printf("return s.decodeInt(&%s)", target)
+	case "int64":
+		c.printf("return s.decodeInt64(&%s)", target)
+	case "uint64":
+		c.printf("return s.decodeUint64(&%s)", target)
+	case "float64":
+		c.printf("return s.decodeFloat64(&%s)", target)
+	case "json.Number":
+		c.printf("return s.decodeNumber(&%s)", target)
+	default:
+		if strings.HasPrefix(typ, "[]") {
+			// Like encoding/json: null resets the slice, an array reuses
+			// its backing store, and an empty one is not nil.
+			item := "item" + strconv.Itoa(depth)
+			c.printf("if s.null() {")
+			c.printf("%s = nil", target)
+			c.printf("return nil")
+			c.printf("}")
+			c.printf("if %s == nil {", target)
+			c.printf("%s = %s{}", target, typ)
+			c.printf("}")
+			c.printf("%s = %s[:0]", target, target)
+			c.printf("return s.array(func() error {")
+			c.printf("var %s %s", item, typ[2:])
+			c.printf("%s = append(%s, %s)", target, target, item)
+			c.decode(typ[2:], target+"[len("+target+")-1]", depth+1)
+			c.printf("})")
+			return
+		}
+		if c.structs[typ] {
+			c.printf("return %s.decodeJSON(s)", target)
+		} else {
+			c.printf("return s.decodeAny(&%s)", target)
+		}
+	}
+}
+
+// codecBenchmarks returns a test file comparing the generated methods of the
+// struct name with encoding/json on sample.
+func codecBenchmarks(pkg, name string, sample []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by json_to_struct. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "import (\n\"encoding/json\"\n\"testing\"\n)\n\n")
+	fmt.Fprintf(&b, "var bench%sSample = []byte(%s)\n\n", name, strconv.Quote(string(sample)))
+	fmt.Fprintf(&b, "// bench%sStd has no methods, so encoding/json uses reflection for it. Nested\n", name)
+	fmt.Fprintf(&b, "// generated types still use their own methods.\n")
+	fmt.Fprintf(&b, "type bench%sStd %s\n\n", name, name)
+	for _, bench := range []struct{ name, body string }{
+		{"UnmarshalGenerated", "var v NAME\nif err := v.UnmarshalJSON(benchNAMESample); err != nil {\nb.Fatal(err)\n}"},
+		{"UnmarshalStd", "var v benchNAMEStd\nif err := json.Unmarshal(benchNAMESample, &v); err != nil {\nb.Fatal(err)\n}"},
+		{"MarshalGenerated", "if _, err := v.MarshalJSON(); err != nil {\nb.Fatal(err)\n}"},
+		{"MarshalStd", "if _, err := json.Marshal((*benchNAMEStd)(&v)); err != nil {\nb.Fatal(err)\n}"},
+	} {
+		src := "func BenchmarkNAME" + bench.name + "(b *testing.B) {\n"
+		if strings.HasPrefix(bench.name, "Marshal") {
+			src += "var v NAME\nif err := json.Unmarshal(benchNAMESample, &v); err != nil {\nb.Fatal(err)\n}\n"
+		}
+		src += "b.SetBytes(int64(len(benchNAMESample)))\nb.ReportAllocs()\n"
+		src += "for i := 0; i < b.N; i++ {\n" + bench.body + "\n}\n}\n\n"
+		b.WriteString(strings.Replace(src, "NAME", name, -1))
+	}
+	if formatted, err := format.Source(b.Bytes()); err == nil {
+		return formatted
+	}
+	return b.Bytes()
+}
+
+// codecImports are the imports codecRuntime needs.
+var codecImports = []string{"encoding/json", "fmt", "math", "strconv", "unicode/utf16", "unicode/utf8"}
+
+// codecRuntime is the code shared by the generated methods. It is written
+// once per package.
+const codecRuntime = `// jsonScanner decodes JSON for the generated UnmarshalJSON methods, without
+// reflection. Object keys are matched exactly, unlike encoding/json.
+type jsonScanner struct {
+	data []byte
+	pos  int
+}
+
+func (s *jsonScanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("json: offset %d: %s", s.pos, fmt.Sprintf(format, args...))
+}
+
+func (s *jsonScanner) skipSpace() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end of the input.
+func (s *jsonScanner) peek() byte {
+	s.skipSpace()
+	if s.pos >= len(s.data) {
+		return 0
+	}
+	return s.data[s.pos]
+}
+
+func (s *jsonScanner) consume(c byte) error {
+	switch s.peek() {
+	case c:
+		s.pos++
+		return nil
+	case 0:
+		return s.errorf("unexpected end of input, expecting %q", c)
+	}
+	return s.errorf("invalid character %q, expecting %q", s.data[s.pos], c)
+}
+
+// literal consumes word if it comes next.
+func (s *jsonScanner) literal(word string) bool {
+	s.skipSpace()
+	if len(s.data)-s.pos < len(word) || string(s.data[s.pos:s.pos+len(word)]) != word {
+		return false
+	}
+	s.pos += len(word)
+	return true
+}
+
+func (s *jsonScanner) null() bool {
+	return s.literal("null")
+}
+
+// end checks that only white space follows the decoded value.
+func (s *jsonScanner) end() error {
+	if s.peek() != 0 {
+		return s.errorf("invalid character %q after top-level value", s.data[s.pos])
+	}
+	return nil
+}
+
+// object calls field for each member of an object, positioned on its value.
+func (s *jsonScanner) object(field func(key string) error) error {
+	if s.null() {
+		return nil
+	}
+	if err := s.consume('{'); err != nil {
+		return err
+	}
+	if s.peek() == '}' {
+		s.pos++
+		return nil
+	}
+	for {
+		key, err := s.string()
+		if err != nil {
+			return err
+		}
+		if err := s.consume(':'); err != nil {
+			return err
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case '}':
+			s.pos++
+			return nil
+		case 0:
+			return s.errorf("unexpected end of input in object")
+		default:
+			return s.errorf("invalid character %q after object member", s.data[s.pos])
+		}
+	}
+}
+
+// array calls item for each element of an array, positioned on its value.
+func (s *jsonScanner) array(item func() error) error {
+	if err := s.consume('['); err != nil {
+		return err
+	}
+	if s.peek() == ']' {
+		s.pos++
+		return nil
+	}
+	for {
+		if err := item(); err != nil {
+			return err
+		}
+		switch s.peek() {
+		case ',':
+			s.pos++
+		case ']':
+			s.pos++
+			return nil
+		case 0:
+			return s.errorf("unexpected end of input in array")
+		default:
+			return s.errorf("invalid character %q after array element", s.data[s.pos])
+		}
+	}
+}
+
+func (s *jsonScanner) string() (string, error) {
+	if err := s.consume('"'); err != nil {
+		return "", err
+	}
+	start := s.pos
+	for s.pos < len(s.data) {
+		switch c := s.data[s.pos]; {
+		case c == '"':
+			s.pos++
+			return string(s.data[start : s.pos-1]), nil
+		case c == '\\':
+			return s.escapedString(start)
+		case c < ' ':
+			return "", s.errorf("invalid character %q in string literal", c)
+		}
+		s.pos++
+	}
+	return "", s.errorf("unexpected end of input in string literal")
+}
+
+func (s *jsonScanner) escapedString(start int) (string, error) {
+	b := append([]byte(nil), s.data[start:s.pos]...)
+	for s.pos < len(s.data) {
+		c := s.data[s.pos]
+		switch {
+		case c == '"':
+			s.pos++
+			return string(b), nil
+		case c < ' ':
+			return "", s.errorf("invalid character %q in string literal", c)
+		case c != '\\':
+			b = append(b, c)
+			s.pos++
+			continue
+		}
+		if s.pos+1 >= len(s.data) {
+			break
+		}
+		e := s.data[s.pos+1]
+		s.pos += 2
+		switch e {
+		case '"', '\\', '/':
+			b = append(b, e)
+		case 'b':
+			b = append(b, '\b')
+		case 'f':
+			b = append(b, '\f')
+		case 'n':
+			b = append(b, '\n')
+		case 'r':
+			b = append(b, '\r')
+		case 't':
+			b = append(b, '\t')
+		case 'u':
+			r, ok := s.hex4()
+			if !ok {
+				return "", s.errorf("invalid \\u escape in string literal")
+			}
+			if utf16.IsSurrogate(r) {
+				r = utf8.RuneError
+				if save := s.pos; s.literal("\\u") {
+					if r2, ok := s.hex4(); ok && utf16.DecodeRune(r, r2) != utf8.RuneError {
+						r = utf16.DecodeRune(r, r2)
+					} else {
+						s.pos = save
+					}
+				}
+			}
+			b = utf8.AppendRune(b, r)
+		default:
+			return "", s.errorf("invalid escape %q in string literal", e)
+		}
+	}
+	return "", s.errorf("unexpected end of input in string literal")
+}
+
+func (s *jsonScanner) hex4() (rune, bool) {
+	if len(s.data)-s.pos < 4 {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(string(s.data[s.pos:s.pos+4]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	s.pos += 4
+	return rune(v), true
+}
+
+// number returns the text of the number literal that comes next, which must
+// follow the JSON grammar: -?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?
+func (s *jsonScanner) number() (string, error) {
+	s.skipSpace()
+	start := s.pos
+	if s.pos < len(s.data) && s.data[s.pos] == '-' {
+		s.pos++
+	}
+	switch {
+	case s.pos < len(s.data) && s.data[s.pos] == '0':
+		s.pos++
+	case s.digits() == 0:
+		return "", s.numberError(start)
+	}
+	if s.pos < len(s.data) && s.data[s.pos] == '.' {
+		s.pos++
+		if s.digits() == 0 {
+			return "", s.numberError(start)
+		}
+	}
+	if s.pos < len(s.data) && (s.data[s.pos] == 'e' || s.data[s.pos] == 'E') {
+		s.pos++
+		if s.pos < len(s.data) && (s.data[s.pos] == '+' || s.data[s.pos] == '-') {
+			s.pos++
+		}
+		if s.digits() == 0 {
+			return "", s.numberError(start)
+		}
+	}
+	if s.pos < len(s.data) && ('0' <= s.data[s.pos] && s.data[s.pos] <= '9' || s.data[s.pos] == '.') {
+		return "", s.numberError(start)
+	}
+	return string(s.data[start:s.pos]), nil
+}
+
+// digits consumes a run of decimal digits and returns its length.
+func (s *jsonScanner) digits() int {
+	start := s.pos
+	for s.pos < len(s.data) && '0' <= s.data[s.pos] && s.data[s.pos] <= '9' {
+		s.pos++
+	}
+	return s.pos - start
+}
+
+func (s *jsonScanner) numberError(start int) error {
+	switch {
+	case s.pos == start && s.pos == len(s.data):
+		return s.errorf("unexpected end of input, expecting a number")
+	case s.pos == start:
+		return s.errorf("invalid character %q, expecting a number", s.data[s.pos])
+	case s.pos == len(s.data):
+		return s.errorf("unexpected end of input in number literal")
+	}
+	return s.errorf("invalid character %q in number literal", s.data[s.pos])
+}
+
+// skipValue consumes the next value, whatever it is.
+func (s *jsonScanner) skipValue() error {
+	switch s.peek() {
+	case '{':
+		return s.object(func(string) error { return s.skipValue() })
+	case '[':
+		return s.array(s.skipValue)
+	case '"':
+		_, err := s.string()
+		return err
+	case 't', 'f', 'n':
+		if s.literal("true") || s.literal("false") || s.null() {
+			return nil
+		}
+		return s.errorf("invalid literal")
+	}
+	_, err := s.number()
+	return err
+}
+
+// The decode functions leave *p unchanged on null, like encoding/json.
+
+func (s *jsonScanner) decodeString(p *string) error {
+	if s.null() {
+		return nil
+	}
+	v, err := s.string()
+	if err != nil {
+		return err
+	}
+	*p = v
+	return nil
+}
+
+func (s *jsonScanner) decodeBool(p *bool) error {
+	switch {
+	case s.null():
+	case s.literal("true"):
+		*p = true
+	case s.literal("false"):
+		*p = false
+	default:
+		return s.errorf("expecting a boolean")
+	}
+	return nil
+}
+
+func (s *jsonScanner) decodeInt(p *int) error {
+	if s.null() {
+		return nil
+	}
+	var v int64
+	if err := s.decodeInt64(&v); err != nil {
+		return err
+	}
+	if int64(int(v)) != v {
+		return s.errorf("number %d overflows int", v)
+	}
+	*p = int(v)
+	return nil
+}
+
+func (s *jsonScanner) decodeInt64(p *int64) error {
+	if s.null() {
+		return nil
+	}
+	lit, err := s.number()
+	if err != nil {
+		return err
+	}
+	if *p, err = strconv.ParseInt(lit, 10, 64); err != nil {
+		return s.errorf("%v", err)
+	}
+	return nil
+}
+
+func (s *jsonScanner) decodeUint64(p *uint64) error {
+	if s.null() {
+		return nil
+	}
+	lit, err := s.number()
+	if err != nil {
+		return err
+	}
+	if *p, err = strconv.ParseUint(lit, 10, 64); err != nil {
+		return s.errorf("%v", err)
+	}
+	return nil
+}
+
+func (s *jsonScanner) decodeFloat64(p *float64) error {
+	if s.null() {
+		return nil
+	}
+	lit, err := s.number()
+	if err != nil {
+		return err
+	}
+	if *p, err = strconv.ParseFloat(lit, 64); err != nil {
+		return s.errorf("%v", err)
+	}
+	return nil
+}
+
+func (s *jsonScanner) decodeNumber(p *json.Number) error {
+	if s.null() {
+		return nil
+	}
+	lit, err := s.number()
+	*p = json.Number(lit)
+	return err
+}
+
+// decodeAny falls back to encoding/json for the types the generator does not
+// know about.
+func (s *jsonScanner) decodeAny(p interface{}) error {
+	start := s.pos
+	if err := s.skipValue(); err != nil {
+		return err
+	}
+	return json.Unmarshal(s.data[start:s.pos], p)
+}
+
+// The append functions encode values like encoding/json does.
+
+func jsonAppendString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				b = append(b, '\\', c)
+			case c == '\n':
+				b = append(b, '\\', 'n')
+			case c == '\r':
+				b = append(b, '\\', 'r')
+			case c == '\t':
+				b = append(b, '\\', 't')
+			case c < ' ' || c == '<' || c == '>' || c == '&':
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+			default:
+				b = append(b, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(b, "\ufffd"...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xf])
+		default:
+			b = append(b, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(b, '"')
+}
+
+func jsonAppendBool(b []byte, v bool) []byte {
+	return strconv.AppendBool(b, v)
+}
+
+func jsonAppendInt(b []byte, v int64) []byte {
+	return strconv.AppendInt(b, v, 10)
+}
+
+func jsonAppendUint(b []byte, v uint64) []byte {
+	return strconv.AppendUint(b, v, 10)
+}
+
+func jsonAppendFloat(b []byte, v float64) ([]byte, error) {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return b, fmt.Errorf("json: unsupported value: %v", v)
+	}
+	format := byte('f')
+	if abs := math.Abs(v); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, v, format, -1, 64)
+	if format == 'e' { // clean up e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+func jsonAppendNumber(b []byte, v json.Number) []byte {
+	if v == "" {
+		return append(b, '0')
+	}
+	return append(b, v...)
+}
+
+func jsonAppendMarshal(b []byte, v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return append(b, data...), err
+}
+`
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const codecSamples = `
+{"id": 1, "big": 9007199254740993, "u": 18446744073709551615, "huge": 123456789012345678901234567890,
+ "price": 1.5, "name": "a\"bé😀", "ok": true, "opt": null, "any": 1,
+ "meta": {"tags": ["x", "y"], "n": [1, 2]}, "items": [{"sku": "a", "qty": 2}]}
+{"id": 2, "big": -5, "u": 1, "huge": 1, "price": 2, "name": "", "ok": false, "opt": "s", "any": "x",
+ "meta": {"tags": [], "n": []}, "items": []}
+`
+
+// codecInputs are decoded by both the generated methods and encoding/json.
+// Keys are exact matches: the generated methods do not fold case.
+var codecInputs = []string{
+	`{}`,
+	` { "id" : 7 , "name" : "tab\there" } `,
+	`{"name": "é😀\n\\\/", "price": -0.5e-3, "big": -9223372036854775808}`,
+	`{"u": 18446744073709551615, "huge": -1.5E+300, "any": {"deep": [1, {"x": null}]}}`,
+	`{"opt": null, "meta": null, "items": null, "name": null}`,
+	`{"items": [{"sku": "b"}, {"qty": 0}], "meta": {"tags": ["<&>"]}}`,
+	`{"unknown": {"a": [1, 2.5e3, "s", true, false, null]}, "id": 3}`,
+	`{"id": 01}`,
+	`{"id": 1-2}`,
+	`{"huge": 01}`,
+	`{"huge": --}`,
+	`{"unknown": 01}`,
+	`{"price": 1.}`,
+	`{"price": .5}`,
+	`{"id": "1"}`,
+	`{"id": 1.5}`,
+	`{"u": -1}`,
+	`{"id": 99999999999999999999}`,
+	`{"name": "\x"}`,
+	`{"name": "unterminated}`,
+	`{"id": 1,}`,
+	`{"id": 1} x`,
+	`[]`,
+	``,
+}
+
+const codecMain = `package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	"roundtrip/codec"
+	"roundtrip/ref"
+)
+
+func main() {
+	var inputs []string
+	if err := json.NewDecoder(os.Stdin).Decode(&inputs); err != nil {
+		panic(err)
+	}
+	failed := false
+	for _, in := range inputs {
+		var c codec.Doc
+		var r ref.Doc
+		cerr := c.UnmarshalJSON([]byte(in))
+		rerr := json.Unmarshal([]byte(in), &r)
+		if (cerr == nil) != (rerr == nil) {
+			fmt.Printf("%s: generated error %v, encoding/json error %v\n", in, cerr, rerr)
+			failed = true
+			continue
+		}
+		if cerr != nil {
+			continue
+		}
+		cout, err := json.Marshal(c)
+		if err != nil {
+			fmt.Printf("%s: generated MarshalJSON: %v\n", in, err)
+			failed = true
+			continue
+		}
+		rout, _ := json.Marshal(r)
+		if !reflect.DeepEqual(decode(cout), decode(rout)) {
+			fmt.Printf("%s: generated %s, encoding/json %s\n", in, cout, rout)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func decode(b []byte) interface{} {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	d.Decode(&v)
+	return v
+}
+`
+
+// TestCodecMatchesEncodingJSON builds the generated methods next to the
+// same types without them, and checks that both decode the same inputs to
+// the same values, and reject the same ones.
+func TestCodecMatchesEncodingJSON(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+
+	root := newNode("")
+	if err := readSamples([]byte(codecSamples), options{}, root); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "codec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"go.mod":       "module roundtrip\n",
+		"main.go":      codecMain,
+		"codec/doc.go": "package codec\n\n" + string(generate("Doc", root, genConfig{codec: true, runtime: true}).src),
+		"ref/doc.go":   "package ref\n\n" + string(generate("Doc", root, genConfig{}).src),
+	}
+	for name, src := range files {
+		file := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := ioutil.WriteFile(file, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inputs, _ := json.Marshal(codecInputs)
+	cmd := exec.Command(goBin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GO111MODULE=on", "GOFLAGS=-mod=mod")
+	cmd.Stdin = strings.NewReader(string(inputs))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("%v\n%s", err, out)
+	}
+}
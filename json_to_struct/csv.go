@@ -0,0 +1,157 @@
+package main
+
+// CSV input: the first record is the header, every other one a sample row.
+// Each column gets the narrowest type that holds all its values: int, int64,
+// float64, bool, time.Time or string. Non-string columns with blank cells
+// become pointers.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are tried in order; a column keeps the first one that parses
+// all of its values.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02", "01/02/2006"}
+
+type column struct {
+	header  string
+	blanks  int
+	example string // first non-blank value
+
+	notInt, notInt32, notFloat, notBool bool
+	layouts                             []string // time layouts that parsed every value so far
+}
+
+func newColumn(header string) *column {
+	return &column{header: header, layouts: timeLayouts}
+}
+
+func (c *column) add(v string) {
+	if strings.TrimSpace(v) == "" {
+		c.blanks++
+		return
+	}
+	if c.example == "" {
+		c.example = v
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+		c.notInt = true
+	}
+	if _, err := strconv.ParseInt(v, 10, 32); err != nil {
+		c.notInt32 = true
+	}
+	if _, err := strconv.ParseFloat(v, 64); err != nil {
+		c.notFloat = true
+	}
+	if _, err := strconv.ParseBool(v); err != nil {
+		c.notBool = true
+	}
+	var layouts []string
+	for _, l := range c.layouts {
+		if _, err := time.Parse(l, v); err == nil {
+			layouts = append(layouts, l)
+		}
+	}
+	c.layouts = layouts
+}
+
+// goType returns the type of the column, and the time layout if it is one.
+func (c *column) goType(rows int) (string, string) {
+	t, layout := "string", ""
+	switch {
+	case c.blanks == rows:
+	case !c.notInt32:
+		t = "int"
+	case !c.notInt:
+		t = "int64"
+	case !c.notFloat:
+		t = "float64"
+	case !c.notBool:
+		t = "bool"
+	case len(c.layouts) > 0:
+		t, layout = "time.Time", c.layouts[0]
+	}
+	if c.blanks > 0 && t != "string" {
+		t = "*" + t
+	}
+	return t, layout
+}
+
+// generateCSV returns a struct named name for the rows of src.
+func generateCSV(name string, src []byte, delim rune, lenient bool) ([]byte, error) {
+	r := csv.NewReader(bytes.NewReader(src))
+	r.Comma = delim
+	r.LazyQuotes = lenient
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header row")
+	}
+
+	columns := make([]*column, len(records[0]))
+	for i, h := range records[0] {
+		columns[i] = newColumn(h)
+	}
+	rows := records[1:]
+	for _, rec := range rows {
+		for i, v := range rec {
+			columns[i].add(v)
+		}
+	}
+
+	var b bytes.Buffer
+	usesTime := false
+	fmt.Fprintf(&b, "// %s was derived from %s.\ntype %s struct {\n", name, plural(len(rows), "row"), name)
+	used := make(map[string]bool)
+	for i, c := range columns {
+		field := uniqueName(exportedName(c.header), used)
+		used[field] = true
+		typ, layout := c.goType(len(rows))
+		usesTime = usesTime || layout != ""
+
+		comment := fmt.Sprintf("%s is column %d %s", field, i+1, strconv.Quote(c.header))
+		if c.blanks > 0 {
+			comment += fmt.Sprintf(" (blank in %d of %s)", c.blanks, plural(len(rows), "row"))
+		}
+		if c.example != "" {
+			comment += ", e.g. " + example(c.example)
+		}
+		comment += "."
+		if layout != "" {
+			comment += " Layout " + layout + "."
+		}
+		fmt.Fprintf(&b, "\t// %s\n", comment)
+		fmt.Fprintf(&b, "\t%s %s `csv:%q json:%q`\n", field, typ, c.header, c.header+",omitempty")
+	}
+	b.WriteString("}\n")
+
+	src = b.Bytes()
+	if usesTime {
+		src = append([]byte("import \"time\"\n\n"), src...)
+	}
+	if formatted, err := format.Source(src); err == nil {
+		return formatted, nil
+	}
+	return src, nil
+}
+
+// parseDelim accepts a single character, or \t or "tab" for tabs.
+func parseDelim(s string) (rune, error) {
+	switch s {
+	case `\t`, "tab":
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+		return 0, fmt.Errorf("invalid -delim %q", s)
+	}
+	return r[0], nil
+}
@@ -0,0 +1,184 @@
+package main
+
+// Diff mode compares the models inferred from two sets of samples of the same
+// endpoint and classifies the differences by whether code generated from the
+// old samples could still decode the new ones.
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type change struct {
+	path     string
+	breaking bool
+	what     string
+}
+
+// runDiff compares the samples in old and new, each a file or a directory of
+// *.json files, and returns the number of breaking changes.
+func runDiff(w io.Writer, oldPath, newPath string, opts options) (int, error) {
+	oldRoot, err := loadSamples(oldPath, opts)
+	if err != nil {
+		return 0, err
+	}
+	newRoot, err := loadSamples(newPath, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	var changes []change
+	diffNodes(oldRoot, newRoot, &changes)
+
+	breaking := 0
+	for _, c := range changes {
+		label := "non-breaking"
+		if c.breaking {
+			label = "BREAKING"
+			breaking++
+		}
+		fmt.Fprintf(w, "%-13s %s: %s\n", label, location(c.path), c.what)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "no changes")
+	} else {
+		fmt.Fprintf(w, "%d breaking, %d non-breaking\n", breaking, len(changes)-breaking)
+	}
+	return breaking, nil
+}
+
+// loadSamples reads the samples in file, or in every *.json file of the
+// directory file.
+func loadSamples(file string, opts options) (*node, error) {
+	files := []string{file}
+	if fi, err := os.Stat(file); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(file, "*.json")); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s: no *.json samples", file)
+		}
+		sort.Strings(files)
+	}
+
+	root := newNode("")
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		before := root.samples()
+		if err := readSamples(data, opts, root); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		if root.samples() == before {
+			return nil, fmt.Errorf("%s: no samples", f)
+		}
+	}
+	return root, nil
+}
+
+// diffNodes appends the differences between before and after, and their
+// descendants, to changes.
+func diffNodes(before, after *node, changes *[]change) {
+	add := func(breaking bool, format string, args ...interface{}) {
+		*changes = append(*changes, change{after.path, breaking, fmt.Sprintf(format, args...)})
+	}
+
+	beforeKind, afterKind := before.kind(), after.kind()
+	switch {
+	case beforeKind == kindNull || afterKind == kindNull:
+		// Only nulls on one side: nothing is known about the type.
+	case beforeKind != afterKind || beforeKind == kindNumber && before.numberType() != after.numberType():
+		add(!assignable(before, after), "type changed from %s to %s", describe(before), describe(after))
+		return
+	}
+
+	if beforeNull, afterNull := before.counts[kindNull] > 0, after.counts[kindNull] > 0; beforeNull != afterNull {
+		if afterNull {
+			add(true, "became nullable")
+		} else {
+			add(false, "no longer null")
+		}
+	}
+
+	if before.elem != nil && after.elem != nil {
+		diffNodes(before.elem, after.elem, changes)
+	}
+
+	keys := make(map[string]bool)
+	for k := range before.fields {
+		keys[k] = true
+	}
+	for k := range after.fields {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		o, n := before.fields[k], after.fields[k]
+		switch {
+		case n == nil && afterKind == kindObject:
+			*changes = append(*changes, change{o.path, true, "removed (" + describe(o) + ")"})
+		case o == nil && beforeKind == kindObject:
+			*changes = append(*changes, change{n.path, false, "added (" + describe(n) + ")"})
+		case o != nil && n != nil:
+			diffNodes(o, n, changes)
+		}
+	}
+}
+
+// assignable reports whether values shaped like after still decode into the
+// type generated for before.
+func assignable(before, after *node) bool {
+	switch before.kind() {
+	case kindMixed:
+		return true
+	case kindNumber:
+		if after.kind() != kindNumber {
+			return false
+		}
+		switch before.numberType() {
+		case "float64", "json.Number":
+			return true
+		case "uint64":
+			return !after.float && !after.negative && !after.nonUint64
+		case "int64":
+			return !after.float && !after.nonInt64
+		}
+		return !after.float && !after.nonInt32
+	}
+	return false
+}
+
+// describe names the shape of n the way the generated code would.
+func describe(n *node) string {
+	switch n.kind() {
+	case kindBool:
+		return "bool"
+	case kindNumber:
+		return n.numberType()
+	case kindString:
+		return "string"
+	case kindObject:
+		return "object"
+	case kindArray:
+		if n.elem == nil || n.elem.samples() == 0 {
+			return "[]unknown"
+		}
+		return "[]" + describe(n.elem)
+	case kindMixed:
+		return "mixed"
+	}
+	return "null"
+}
@@ -0,0 +1,249 @@
+package main
+
+// Gen mode is meant for go:generate:
+//
+//	//go:generate json_to_struct gen testdata
+//
+// Every NAME.sample.json and NAME.*.sample.json file of the directory is a
+// sample of the type NAME, written to zz_generated_NAME.go in the current
+// directory. NAME.overrides.json, if present, is used as the -config file for
+// that type. Files are only rewritten when their content changes, generated
+// files that are no longer produced are removed, and overrides that
+// contradict the samples are errors. With -codec, the scanner
+// the methods share goes to zz_generated_jsoncodec.go, and benchmarks against
+// encoding/json to zz_generated_NAME_test.go.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const sampleSuffix = ".sample.json"
+
+// sampleSets groups the sample files of dir by type name.
+func sampleSets(dir string) (map[string][]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+sampleSuffix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	sets := make(map[string][]string)
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), sampleSuffix)
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[:i]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: no type name before %s", f, sampleSuffix)
+		}
+		sets[name] = append(sets[name], f)
+	}
+	return sets, nil
+}
+
+// runGen generates a file in outDir for every sample set of dir, in package
+// pkg, and returns the files it wrote and the ones it removed: generated
+// files of outDir that this run no longer produces. With codec, it also
+// writes the scanner the methods share and benchmarks for each type.
+func runGen(dir, outDir, pkg string, opts options, codec bool) (written, removed []string, err error) {
+	sets, err := sampleSets(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sets) == 0 {
+		return nil, nil, fmt.Errorf("%s: no *%s files", dir, sampleSuffix)
+	}
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The sets share the package, so their nested types must not take the
+	// name of another set's type. The root types keep their names.
+	used := make(map[string]bool)
+	for _, name := range names {
+		used[exportedName(name)] = true
+	}
+	gen := make(map[string][]byte)
+	for _, name := range names {
+		delete(used, exportedName(name))
+		files, err := genFiles(name, sets[name], filepath.Join(dir, name+".overrides.json"), pkg, opts, codec, used)
+		if err != nil {
+			return nil, nil, err
+		}
+		for file, src := range files {
+			gen[file] = src
+		}
+	}
+	if codec {
+		src := fileHeader("", pkg) + "import (\n\"" + strings.Join(codecImports, "\"\n\"") + "\"\n)\n\n" + codecRuntime
+		gen["zz_generated_jsoncodec.go"] = []byte(src)
+	}
+	if err := typeCheck(outDir, pkg, gen); err != nil {
+		return nil, nil, err
+	}
+
+	for file, src := range gen {
+		if err := writeIfChanged(filepath.Join(outDir, file), src, &written); err != nil {
+			return written, nil, err
+		}
+	}
+	sort.Strings(written)
+	removed, err = removeStale(outDir, gen)
+	return written, removed, err
+}
+
+// removeStale removes the files of outDir that an earlier run generated and
+// that are not in gen, e.g. after a sample set or -codec was dropped.
+func removeStale(outDir string, gen map[string][]byte) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(outDir, "zz_generated_*.go"))
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, f := range files {
+		if _, ok := gen[filepath.Base(f)]; ok {
+			continue
+		}
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			return removed, err
+		}
+		if !bytes.HasPrefix(src, []byte("// Code generated by json_to_struct")) {
+			continue
+		}
+		if err := os.Remove(f); err != nil {
+			return removed, err
+		}
+		removed = append(removed, f)
+	}
+	return removed, nil
+}
+
+// typeCheck checks that the generated files, tests aside, compile together
+// with the other files of package pkg in outDir, so that a broken package is
+// never written. Overrides may name types declared in those files. Imports
+// that cannot be found are left to the compiler.
+func typeCheck(outDir, pkg string, gen map[string][]byte) error {
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for name, src := range gen {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			return fmt.Errorf("generated invalid code: %v", err)
+		}
+		files = append(files, f)
+	}
+	bp, err := build.ImportDir(outDir, 0)
+	if _, ok := err.(*build.NoGoError); err != nil && !ok {
+		return err
+	}
+	if bp != nil {
+		for _, name := range bp.GoFiles {
+			if strings.HasPrefix(name, "zz_generated_") {
+				continue
+			}
+			f, err := parser.ParseFile(fset, filepath.Join(outDir, name), nil, 0)
+			if err != nil {
+				return err
+			}
+			files = append(files, f)
+		}
+	}
+
+	var first error
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "source", nil),
+		Error: func(err error) {
+			if te, ok := err.(types.Error); ok && strings.HasPrefix(te.Msg, "could not import") {
+				return
+			}
+			if first == nil {
+				first = err
+			}
+		},
+	}
+	conf.Check(pkg, fset, files, nil)
+	if first != nil {
+		return fmt.Errorf("generated code does not compile: %v", first)
+	}
+	return nil
+}
+
+func writeIfChanged(file string, src []byte, written *[]string) error {
+	if old, err := ioutil.ReadFile(file); err == nil && bytes.Equal(old, src) {
+		return nil
+	}
+	if err := ioutil.WriteFile(file, src, 0644); err != nil {
+		return err
+	}
+	*written = append(*written, file)
+	return nil
+}
+
+func fileHeader(from, pkg string) string {
+	if from != "" {
+		from = " from " + from
+	}
+	return fmt.Sprintf("// Code generated by json_to_struct%s. DO NOT EDIT.\n\npackage %s\n\n", from, pkg)
+}
+
+// genFiles returns the files generated for the sample set name, by file name.
+// The types get names not in used, which the new names are added to.
+func genFiles(name string, files []string, config, pkg string, opts options, codec bool, used map[string]bool) (map[string][]byte, error) {
+	var ov *overrides
+	if _, err := os.Stat(config); err == nil {
+		if ov, err = loadOverrides(config); err != nil {
+			return nil, err
+		}
+	}
+
+	root := newNode("")
+	bases := make([]string, len(files))
+	for i, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if err := readSamples(data, opts, root); err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		bases[i] = filepath.Base(f)
+	}
+
+	out := generate(exportedName(name), root, genConfig{ov: ov, codec: codec, names: used})
+	if len(out.problems) > 0 {
+		return nil, fmt.Errorf("%s: samples contradict overrides:\n\t%s", name, strings.Join(out.problems, "\n\t"))
+	}
+
+	src, err := format.Source(append([]byte(fileHeader(strings.Join(bases, ", "), pkg)), out.src...))
+	if err != nil {
+		return nil, fmt.Errorf("%s: generated invalid code: %v", name, err)
+	}
+	gen := map[string][]byte{"zz_generated_" + name + ".go": src}
+	if codec && out.isStruct {
+		sample, err := json.Marshal(root.example)
+		if err != nil {
+			return nil, err
+		}
+		gen["zz_generated_"+name+"_test.go"] = codecBenchmarks(pkg, out.root, sample)
+	}
+	return gen, nil
+}
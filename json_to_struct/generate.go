@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const exampleLen = 40 // maximum length of the example value in field comments
+
+// generator turns a model into Go type declarations, in the order the types
+// are first referenced.
+type generator struct {
+	decls   []string
+	names   map[string]bool
+	imports map[string]bool
+	structs []structInfo
+	ov      *overrides
+
+	problems []string
+}
+
+// structInfo describes a generated struct for the codec methods.
+type structInfo struct {
+	name   string
+	fields []fieldInfo
+}
+
+type fieldInfo struct {
+	name, typ string
+	jsonTag   string // value of the json struct tag
+}
+
+func newGenerator(ov *overrides) *generator {
+	return &generator{names: make(map[string]bool), imports: make(map[string]bool), ov: ov}
+}
+
+// genConfig selects what generate writes besides the type declarations.
+type genConfig struct {
+	ov      *overrides
+	codec   bool // add MarshalJSON and UnmarshalJSON methods that do not use reflection
+	runtime bool // with codec, also add the scanner the methods use
+
+	// names, if not nil, are the type names already declared in the package.
+	// The types generated are added to it, so that several calls can write
+	// to the same package.
+	names map[string]bool
+}
+
+type generated struct {
+	src      []byte
+	problems []string // overrides that contradict the samples or match nothing
+	root     string   // name of the root type
+	isStruct bool     // whether the root type is a struct
+}
+
+// generate returns the formatted declarations for root and its nested types,
+// preceded by the imports that overridden types need.
+func generate(name string, root *node, cfg genConfig) generated {
+	g := newGenerator(cfg.ov)
+	if cfg.names != nil {
+		g.names = cfg.names
+	}
+	if r := cfg.ov.lookup(root.path); r.TypeName != "" {
+		name = r.TypeName
+	}
+	out := generated{isStruct: root.kind() == kindObject}
+	if out.isStruct {
+		out.root = g.declareStruct(name, root)
+	} else {
+		out.root = name
+		i := g.reserve(name)
+		g.decls[i] = fmt.Sprintf("// %s %s\ntype %s %s\n", name, derivedFrom(root), name, g.typeFor(root, name))
+	}
+
+	for _, p := range cfg.ov.unused() {
+		g.problems = append(g.problems, fmt.Sprintf("override %q matched nothing", p))
+	}
+	out.problems = g.problems
+
+	if cfg.codec {
+		g.decls = append(g.decls, g.codecMethods())
+		if cfg.runtime {
+			g.decls = append(g.decls, codecRuntime)
+			for _, p := range codecImports {
+				g.imports[p] = true
+			}
+		}
+	}
+
+	out.src = []byte(g.importDecl() + strings.Join(g.decls, "\n"))
+	if formatted, err := format.Source(out.src); err == nil {
+		out.src = formatted
+	}
+	return out
+}
+
+// reserve claims a slot for a declaration named name. Nested types are
+// declared while the enclosing one is still being written, so the slot keeps
+// the enclosing type first.
+func (g *generator) reserve(name string) int {
+	g.names[name] = true
+	g.decls = append(g.decls, "")
+	return len(g.decls) - 1
+}
+
+// uniqueName returns name, or name with a numeric suffix if it is already used.
+func uniqueName(name string, used map[string]bool) string {
+	if !used[name] {
+		return name
+	}
+	for i := 2; ; i++ {
+		if s := name + strconv.Itoa(i); !used[s] {
+			return s
+		}
+	}
+}
+
+func (g *generator) importDecl() string {
+	if len(g.imports) == 0 {
+		return ""
+	}
+	paths := make([]string, 0, len(g.imports))
+	for p := range g.imports {
+		paths = append(paths, strconv.Quote(p))
+	}
+	sort.Strings(paths)
+	return "import (\n" + strings.Join(paths, "\n") + "\n)\n\n"
+}
+
+// typeFor returns the Go type for n. name is used for struct types, unless
+// the overrides give one.
+func (g *generator) typeFor(n *node, name string) string {
+	r := g.ov.lookup(n.path)
+	if r.Type != "" {
+		if msg := contradiction(r.Type, n); msg != "" {
+			g.problems = append(g.problems, fmt.Sprintf("override %q: %s", n.path, msg))
+		}
+		if r.Import != "" {
+			g.imports[r.Import] = true
+		}
+		return r.Type
+	}
+	if r.TypeName != "" {
+		name = r.TypeName
+	}
+	switch n.kind() {
+	case kindBool:
+		return "bool"
+	case kindNumber:
+		t := n.numberType()
+		if t == "json.Number" {
+			g.imports["encoding/json"] = true
+		}
+		return t
+	case kindObject:
+		return g.declareStruct(name, n)
+	case kindArray:
+		if n.elem == nil || n.elem.samples() == 0 {
+			return "[]string"
+		}
+		return "[]" + g.typeFor(n.elem, name+"Item")
+	case kindMixed:
+		return "interface{}"
+	}
+	return "string"
+}
+
+func (g *generator) declareStruct(name string, n *node) string {
+	name = uniqueName(exportedName(name), g.names)
+	i := g.reserve(name)
+
+	j := len(g.structs)
+	g.structs = append(g.structs, structInfo{name: name})
+	var fields []fieldInfo
+	used := make(map[string]bool)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s %s\ntype %s struct {\n", name, derivedFrom(n), name)
+	for _, k := range n.sortedKeys() {
+		f := n.fields[k]
+		r := g.ov.lookup(f.path)
+		if r.Skip {
+			continue
+		}
+		if k == "" {
+			// A json tag cannot name the empty key: "" means the field name.
+			b.WriteString("\t// The empty key \"\" has no field, encoding/json cannot map it.\n")
+			continue
+		}
+		fieldName := r.Name
+		if fieldName == "" {
+			fieldName = uniqueName(exportedName(k), used)
+		}
+		used[fieldName] = true
+		typ := g.typeFor(f, fieldName)
+		fmt.Fprintf(&b, "\t// %s\n", fieldComment(fieldName, f, n.counts[kindObject]))
+		fmt.Fprintf(&b, "\t%s %s `%s`\n", fieldName, typ, r.tag(k))
+		fields = append(fields, fieldInfo{fieldName, typ, r.jsonTag(k)})
+	}
+	b.WriteString("}\n")
+	g.decls[i] = b.String()
+	g.structs[j].fields = fields
+	return name
+}
+
+// contradiction explains why a forced builtin type cannot hold the values
+// observed at n. Other types are not checked.
+func contradiction(typ string, n *node) string {
+	k := n.kind()
+	if k == kindNull {
+		return ""
+	}
+	want := ""
+	switch typ {
+	case "bool":
+		want = "bool"
+	case "string":
+		want = "string"
+	case "float32", "float64", "json.Number":
+		want = "number"
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		want = "number"
+		if k == kindNumber && n.float {
+			return typ + " cannot hold the decimals in the samples"
+		}
+		if k == kindNumber && n.negative && strings.HasPrefix(typ, "uint") {
+			return typ + " cannot hold the negative numbers in the samples"
+		}
+	default:
+		return ""
+	}
+	if got := describeKind(k); got != want {
+		return fmt.Sprintf("samples have %s values, not %s", got, typ)
+	}
+	return ""
+}
+
+func derivedFrom(n *node) string {
+	s := "was derived from " + plural(n.samples(), "sample")
+	if n.path != "" {
+		s += " at " + n.path
+	}
+	return s + "."
+}
+
+// fieldComment describes where field f comes from and what it looks like.
+// parents is the number of objects the field could have appeared in.
+func fieldComment(name string, f *node, parents int) string {
+	s := name + " is " + f.path
+	if seen := f.samples(); seen < parents {
+		s += fmt.Sprintf(" (present in %d of %s)", seen, plural(parents, "sample"))
+	}
+	s += ", e.g. " + example(f.example) + "."
+	if f.kind() == kindNumber && f.numberType() == "json.Number" {
+		s += " Too large for uint64, kept as json.Number."
+	}
+	return s
+}
+
+// example returns v as compact JSON, truncated to about exampleLen runes
+// without cutting an escape sequence in two.
+func example(v interface{}) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "?"
+	}
+	r := []rune(strings.TrimSuffix(buf.String(), "\n"))
+	if len(r) <= exampleLen {
+		return string(r)
+	}
+	end := 0
+	for end < exampleLen {
+		next := end + 1
+		if r[end] == '\\' {
+			next = end + 2
+			if r[end+1] == 'u' {
+				next = end + 6
+			}
+		}
+		if next > exampleLen {
+			break
+		}
+		end = next
+	}
+	return string(r[:end]) + "..."
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
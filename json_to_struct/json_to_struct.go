@@ -1,79 +1,311 @@
 package main
 
 // Print struct template for a given json string.
-// If there are multiple definitions for the same object, print the first one only.
+// Several documents may be given one after the other; each is a sample of the
+// same object and their fields are merged.
 
 import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"unicode"
 )
 
+var (
+	lenient   = flag.Bool("lenient", false, "accept JSONC and JSON5 input (comments, trailing commas, single quotes, unquoted keys)")
+	path      = flag.String("path", "", "generate types for the value at this JSON Pointer (/data/items/0) or path (data.items[0])")
+	unwrap    = flag.Bool("unwrap", false, "if the selected value is an array, generate the type of its items")
+	config    = flag.String("config", "", "JSON `file` of overrides for types, names, skipped fields and tags")
+	pkg       = flag.String("pkg", os.Getenv("GOPACKAGE"), "package of the generated files (gen)")
+	outDir    = flag.String("out", ".", "`directory` of the generated files (gen)")
+	codec     = flag.Bool("codec", false, "generate MarshalJSON and UnmarshalJSON methods that do not use reflection")
+	bench     = flag.String("bench", "", "with -codec, write benchmarks against encoding/json on the first sample to `file`")
+	outFormat = flag.String("format", "go", "output `format`: go or schema (JSON Schema)")
+	csvIn     = flag.Bool("csv", false, "read CSV with a header row instead of JSON")
+	delim     = flag.String("delim", ",", "CSV field delimiter, a single character or \\t")
+	addr      = flag.String("addr", os.Getenv("APP_ADDR"), "`address` to serve on, e.g. localhost:8080; FastCGI on standard input if empty (serve)")
+)
+
 func main() {
+	flag.Usage = usage
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "diff":
+			flag.CommandLine.Parse(args[1:])
+			diffMain()
+			return
+		case "gen":
+			flag.CommandLine.Parse(args[1:])
+			genMain()
+			return
+		case "serve":
+			flag.CommandLine.Parse(args[1:])
+			if err := serve(*addr); err != nil {
+				fatalf("serve: %v", err)
+			}
+			return
+		}
+	}
+	flag.CommandLine.Parse(args)
+
 	reader := bufio.NewReader(os.Stdin)
 	inBytes, err := ioutil.ReadAll(reader)
 	if err != nil {
-		panic(err)
+		fatalf("%v", err)
+	}
+
+	if *csvIn {
+		csvMain(inBytes)
+		return
 	}
 
-	j := string(inBytes)
+	tokens, err := parsePath(*path)
+	if err != nil {
+		fatalf("%v", err)
+	}
 
-	var m map[string]interface{}
-	if err := json.Unmarshal([]byte(j), &m); err != nil {
-		panic(err)
+	var ov *overrides
+	if *config != "" {
+		if ov, err = loadOverrides(*config); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	root := newNode("")
+	if err := readSamples(inBytes, options{*lenient, tokens, *unwrap}, root); err != nil {
+		fatalf("%v", err)
+	}
+
+	switch *outFormat {
+	case "go":
+	case "schema":
+		src, err := jsonSchema("MyStruct", root)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		os.Stdout.Write(append(src, '\n'))
+		return
+	default:
+		fatalf("unknown -format %q, want go or schema", *outFormat)
+	}
+
+	out := generate("MyStruct", root, genConfig{ov: ov, codec: *codec, runtime: true})
+	os.Stdout.Write(out.src)
+	for _, p := range out.problems {
+		fmt.Fprintf(os.Stderr, "json_to_struct: warning: %s\n", p)
+	}
+	if *codec && *bench != "" {
+		if !out.isStruct {
+			fatalf("-bench needs an object at the root")
+		}
+		sample, err := json.Marshal(root.example)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		benchPkg := *pkg
+		if benchPkg == "" {
+			benchPkg = "main"
+		}
+		if err := ioutil.WriteFile(*bench, codecBenchmarks(benchPkg, out.root, sample), 0644); err != nil {
+			fatalf("%v", err)
+		}
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: json_to_struct [flags] < samples.json
+       json_to_struct diff [flags] old new
+       json_to_struct gen [flags] [dir]
+       json_to_struct serve [-addr address]
+
+diff compares two sets of samples, each a file or a directory of *.json files,
+and exits with status 1 if the new samples break code generated from the old.
+
+gen writes zz_generated_NAME.go for the NAME.sample.json and NAME.*.sample.json
+files of dir, using NAME.overrides.json as the -config file when present.
+
+serve accepts samples with POST /generate and returns Go source or a JSON
+Schema; / has a form for browsers.
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func diffMain() {
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+	tokens, err := parsePath(*path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	breaking, err := runDiff(os.Stdout, flag.Arg(0), flag.Arg(1), options{*lenient, tokens, *unwrap})
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if breaking > 0 {
+		os.Exit(1)
 	}
+}
 
-	printStructForMap("MyStruct", m)
+func csvMain(in []byte) {
+	if *path != "" || *config != "" || *codec || *outFormat != "go" {
+		fatalf("-csv does not support -path, -config, -codec or -format")
+	}
+	d, err := parseDelim(*delim)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	src, err := generateCSV("MyStruct", in, d, *lenient)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	os.Stdout.Write(src)
+}
 
-	for _, v := range structz {
-		fmt.Printf("%s\n", v)
+func genMain() {
+	dir := "."
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		dir = flag.Arg(0)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if *pkg == "" {
+		fatalf("gen: -pkg is required outside of go generate")
+	}
+	tokens, err := parsePath(*path)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	written, removed, err := runGen(dir, *outDir, *pkg, options{*lenient, tokens, *unwrap}, *codec)
+	for _, f := range written {
+		fmt.Fprintf(os.Stderr, "json_to_struct: wrote %s\n", f)
+	}
+	for _, f := range removed {
+		fmt.Fprintf(os.Stderr, "json_to_struct: removed %s\n", f)
+	}
+	if err != nil {
+		fatalf("gen: %v", err)
 	}
 }
 
-var structz = make(map[string]string)
-
-func printStructForMap(name string, m map[string]interface{}) {
-	var b bytes.Buffer
-
-	b.WriteString(fmt.Sprintf("// %s ...\ntype %s struct {\n", capitalize(name), capitalize(name)))
-	for k, v := range m {
-		if v == nil {
-			b.WriteString(fmt.Sprintf("  %s string `json:\"%s,omitempty\"`\n", capitalize(k), k))
-		} else {
-			switch t := v.(type) {
-			case map[string]interface{}:
-				b.WriteString(fmt.Sprintf("  %s %s `json:\"%s,omitempty\"`\n", capitalize(k), capitalize(k), k))
-				printStructForMap(k, v.(map[string]interface{}))
-			case []interface{}:
-				b.WriteString(fmt.Sprintf("  %s []string `json:\"%s,omitempty\"`\n", capitalize(k), k))
-				for _, item := range t {
-					switch item.(type) {
-					case map[string]interface{}:
-						printStructForMap(capitalize(k)+"Item", item.(map[string]interface{}))
-					}
-				}
-			case float64:
-				vv := v.(float64)
-				if vv == float64(int64(vv)) {
-					b.WriteString(fmt.Sprintf("  %s int `json:\"%s,omitempty\"`\n", capitalize(k), k))
-				} else {
-					b.WriteString(fmt.Sprintf("  %s float64 `json:\"%s,omitempty\"`\n", capitalize(k), k))
-				}
-			default:
-				b.WriteString(fmt.Sprintf("  %s %T `json:\"%s,omitempty\"`\n", capitalize(k), t, k))
+// options control how the input is turned into samples.
+type options struct {
+	lenient bool
+	path    []string // reference tokens of the selected subtree
+	unwrap  bool     // add the items of a selected array as samples
+}
+
+// readSamples decodes every document in src and adds the selected value of
+// each to root.
+func readSamples(src []byte, opts options, root *node) error {
+	i := 0
+	return decodeSamples(src, opts.lenient, func(v interface{}) error {
+		i++
+		v, err := selectPath(v, opts.path)
+		if err != nil {
+			return fmt.Errorf("sample %d: %v", i, err)
+		}
+		if !opts.unwrap {
+			root.add(v)
+			return nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("sample %d: cannot unwrap %s, it is not an array", i, location(pointer(opts.path)))
+		}
+		for _, item := range items {
+			root.add(item)
+		}
+		return nil
+	})
+}
+
+// decodeSamples decodes every JSON document in src and passes it to add.
+// Syntax errors are reported with their line and column in src.
+func decodeSamples(src []byte, lenient bool, add func(interface{}) error) error {
+	data, offsets := src, []int(nil)
+	if lenient {
+		var err error
+		if data, offsets, err = normalize(src); err != nil {
+			return err
+		}
+	}
+	// origin maps an offset in data back to src.
+	origin := func(offset int) int {
+		if offsets == nil {
+			return offset
+		}
+		if offset < len(offsets) {
+			return offsets[offset]
+		}
+		return len(src)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			return nil
+		}
+		switch e := err.(type) {
+		case nil:
+			if err := add(v); err != nil {
+				return err
 			}
+			continue
+		case *json.SyntaxError:
+			// Offset counts the bytes read, including the offending one.
+			return newSyntaxError(src, origin(int(e.Offset)-1), e.Error())
+		}
+		if err == io.ErrUnexpectedEOF {
+			return newSyntaxError(src, len(src), "unexpected end of input")
 		}
+		return err
 	}
-	b.WriteString(fmt.Sprintf("}\n"))
-	structz[name] = b.String()
 }
 
-func capitalize(s string) string {
-	a := []rune(s)
-	a[0] = unicode.ToUpper(a[0])
-	return string(a)
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "json_to_struct: "+format+"\n", args...)
+	os.Exit(2)
+}
+
+// exportedName turns a JSON key or CSV header into an exported Go
+// identifier: characters that cannot appear in one separate words, which are
+// capitalized.
+func exportedName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			upper = true
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteByte('X')
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "X"
+	}
+	return b.String()
 }
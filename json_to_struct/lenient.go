@@ -0,0 +1,320 @@
+package main
+
+// Lenient input: JSONC and JSON5 are rewritten into plain JSON before they
+// are decoded. Comments are dropped, trailing commas removed, single-quoted
+// strings and unquoted keys quoted, and JSON5 numbers (hex, leading '+',
+// leading or trailing '.') converted.
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// syntaxError reports a problem at a position of the original input.
+type syntaxError struct {
+	line, col int
+	msg       string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.line, e.col, e.msg)
+}
+
+// newSyntaxError locates offset in src. Columns count runes, starting at 1.
+func newSyntaxError(src []byte, offset int, msg string) *syntaxError {
+	if offset > len(src) {
+		offset = len(src)
+	} else if offset < 0 {
+		offset = 0
+	}
+	line, start := 1, 0
+	for i := 0; i < offset; i++ {
+		if src[i] == '\n' {
+			line, start = line+1, i+1
+		}
+	}
+	return &syntaxError{line, utf8.RuneCount(src[start:offset]) + 1, msg}
+}
+
+// normalizer rewrites lenient input into JSON. offsets[i] is the position in
+// src of the byte written at out[i], so that decoding errors can be reported
+// against what the user wrote.
+type normalizer struct {
+	src     []byte
+	pos     int
+	out     bytes.Buffer
+	offsets []int
+}
+
+func normalize(src []byte) ([]byte, []int, error) {
+	n := &normalizer{src: src}
+	for n.pos < len(n.src) {
+		if err := n.next(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return n.out.Bytes(), n.offsets, nil
+}
+
+func (n *normalizer) emit(s string, at int) {
+	n.out.WriteString(s)
+	for i := 0; i < len(s); i++ {
+		n.offsets = append(n.offsets, at)
+	}
+}
+
+func (n *normalizer) errorf(at int, format string, args ...interface{}) error {
+	return newSyntaxError(n.src, at, fmt.Sprintf(format, args...))
+}
+
+func (n *normalizer) next() error {
+	c := n.src[n.pos]
+	switch {
+	case c == '/':
+		return n.skipComment()
+	case c == '"' || c == '\'':
+		return n.quoted(c)
+	case c == ',':
+		if !n.trailingComma() {
+			n.emit(",", n.pos)
+		}
+		n.pos++
+	case c == '+' || c == '-' || c == '.' || isDigit(c):
+		return n.number()
+	case c >= utf8.RuneSelf && n.space():
+	case isIdentStart(c):
+		return n.identifier()
+	default:
+		n.emit(string(c), n.pos)
+		n.pos++
+	}
+	return nil
+}
+
+func (n *normalizer) skipComment() error {
+	start := n.pos
+	if n.pos+1 >= len(n.src) {
+		return n.errorf(start, "invalid character '/'")
+	}
+	switch n.src[n.pos+1] {
+	case '/':
+		if i := bytes.IndexByte(n.src[n.pos:], '\n'); i >= 0 {
+			n.pos += i
+		} else {
+			n.pos = len(n.src)
+		}
+	case '*':
+		i := bytes.Index(n.src[n.pos+2:], []byte("*/"))
+		if i < 0 {
+			return n.errorf(start, "unterminated comment")
+		}
+		n.pos += i + 4
+		n.emit(" ", start) // keep tokens on both sides apart
+	default:
+		return n.errorf(start, "invalid character '/'")
+	}
+	return nil
+}
+
+// trailingComma reports whether the comma at pos is only followed by
+// whitespace and comments before a closing bracket.
+func (n *normalizer) trailingComma() bool {
+	c := n.peekToken(n.pos + 1)
+	return c == '}' || c == ']'
+}
+
+// peekToken returns the first byte from offset from on that is neither
+// whitespace nor part of a comment, or 0 if there is none.
+func (n *normalizer) peekToken(from int) byte {
+	for i := from; i < len(n.src); {
+		switch c := n.src[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '/' && i+1 < len(n.src) && n.src[i+1] == '/':
+			j := bytes.IndexByte(n.src[i:], '\n')
+			if j < 0 {
+				return 0
+			}
+			i += j
+		case c == '/' && i+1 < len(n.src) && n.src[i+1] == '*':
+			j := bytes.Index(n.src[i+2:], []byte("*/"))
+			if j < 0 {
+				return 0
+			}
+			i += j + 4
+		case c >= utf8.RuneSelf:
+			r, size := utf8.DecodeRune(n.src[i:])
+			if r != '\ufeff' && !unicode.IsSpace(r) {
+				return c
+			}
+			i += size
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// quoted copies a single- or double-quoted string as a double-quoted one.
+func (n *normalizer) quoted(quote byte) error {
+	start := n.pos
+	n.emit(`"`, n.pos)
+	n.pos++
+	for n.pos < len(n.src) {
+		c := n.src[n.pos]
+		switch {
+		case c == quote:
+			n.emit(`"`, n.pos)
+			n.pos++
+			return nil
+		case c == '\n':
+			return n.errorf(n.pos, "newline in string")
+		case c == '"':
+			n.emit(`\"`, n.pos)
+			n.pos++
+		case c == '\\':
+			if n.pos+1 >= len(n.src) {
+				return n.errorf(start, "unterminated string")
+			}
+			if err := n.escape(); err != nil {
+				return err
+			}
+		default:
+			n.emit(string(c), n.pos)
+			n.pos++
+		}
+	}
+	return n.errorf(start, "unterminated string")
+}
+
+func (n *normalizer) escape() error {
+	at := n.pos
+	c := n.src[n.pos+1]
+	n.pos += 2
+	switch c {
+	case '\'':
+		n.emit("'", at)
+	case '\n': // line continuation
+	case '\r':
+		if n.pos < len(n.src) && n.src[n.pos] == '\n' {
+			n.pos++
+		}
+	case '0':
+		n.emit(`\u0000`, at)
+	case 'v':
+		n.emit(`\u000b`, at)
+	case 'x':
+		if n.pos+2 > len(n.src) {
+			return n.errorf(at, "invalid escape sequence")
+		}
+		v, err := strconv.ParseUint(string(n.src[n.pos:n.pos+2]), 16, 8)
+		if err != nil {
+			return n.errorf(at, "invalid escape sequence")
+		}
+		n.emit(fmt.Sprintf(`\u%04x`, v), at)
+		n.pos += 2
+	case '"', '\\', '/', 'b', 'f', 'n', 'r', 't', 'u':
+		n.emit(`\`+string(c), at)
+	default:
+		n.emit(string(c), at) // JSON5 drops the backslash of unknown escapes
+	}
+	return nil
+}
+
+func (n *normalizer) number() error {
+	start := n.pos
+	end := n.pos + 1
+	for end < len(n.src) && isNumberChar(n.src[end]) {
+		end++
+	}
+	n.pos = end
+
+	lit := string(n.src[start:end])
+	sign := ""
+	switch lit[0] {
+	case '-':
+		sign, lit = "-", lit[1:]
+	case '+':
+		lit = lit[1:]
+	}
+	if lit == "" && end < len(n.src) && isIdentStart(n.src[end]) {
+		return n.errorf(start, "%s cannot be represented in JSON", n.word(end))
+	}
+	switch {
+	case strings.HasPrefix(lit, "0x") || strings.HasPrefix(lit, "0X"):
+		v, err := strconv.ParseUint(lit[2:], 16, 64)
+		if err != nil {
+			return n.errorf(start, "invalid number %q", n.src[start:end])
+		}
+		lit = strconv.FormatUint(v, 10)
+	default:
+		if strings.HasPrefix(lit, ".") {
+			lit = "0" + lit
+		}
+		lit = strings.Replace(lit, ".e", "e", 1)
+		lit = strings.Replace(lit, ".E", "E", 1)
+		lit = strings.TrimSuffix(lit, ".")
+	}
+	n.emit(sign+lit, start)
+	return nil
+}
+
+// space skips the byte order mark and the non-ASCII white space JSON5
+// allows between tokens.
+func (n *normalizer) space() bool {
+	r, size := utf8.DecodeRune(n.src[n.pos:])
+	if r != '\ufeff' && !unicode.IsSpace(r) {
+		return false
+	}
+	n.emit(" ", n.pos)
+	n.pos += size
+	return true
+}
+
+// identifier quotes a bare word used as an object key, and copies the JSON
+// literals. Any other bare word is an error.
+func (n *normalizer) identifier() error {
+	start := n.pos
+	word := n.word(n.pos)
+	n.pos += len(word)
+	switch {
+	case n.peekToken(n.pos) == ':':
+		n.emit(strconv.Quote(word), start)
+	case word == "true" || word == "false" || word == "null":
+		n.emit(word, start)
+	case word == "Infinity" || word == "NaN":
+		return n.errorf(start, "%s cannot be represented in JSON", word)
+	default:
+		switch lower := strings.ToLower(word); lower {
+		case "true", "false", "null":
+			return n.errorf(start, "invalid value %s, did you mean %s?", word, lower)
+		}
+		return n.errorf(start, "invalid value %s, only object keys may be left unquoted", word)
+	}
+	return nil
+}
+
+func (n *normalizer) word(from int) string {
+	end := from
+	for end < len(n.src) && (isIdentStart(n.src[end]) || isDigit(n.src[end])) {
+		end++
+	}
+	return string(n.src[from:end])
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isNumberChar(c byte) bool {
+	return isDigit(c) || c == '.' || c == '+' || c == '-' ||
+		('a' <= c && c <= 'f') || ('A' <= c && c <= 'F') || c == 'x' || c == 'X'
+}
+
+func isIdentStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '$' || c >= utf8.RuneSelf
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{"{\n  // comment\n  a: 1, /* more */ b: 2,\n}", `{"a": 1, "b": 2}`},
+		{`[1, 2, ]`, `[1, 2]`},
+		{`{'a': 'it\'s "quoted"'}`, `{"a": "it's \"quoted\""}`},
+		{`{$id: 1, _x9: 2, é: 3}`, `{"$id": 1, "_x9": 2, "é": 3}`},
+		{`{true: 1, null : 2}`, `{"true": 1, "null": 2}`},
+		{`{a: true, b: false, c: null}`, `{"a": true, "b": false, "c": null}`},
+		{`[0x1F, +1, .5, 5., -.5e1]`, `[31, 1, 0.5, 5, -5]`},
+		{"\ufeff{a:\u00a0[1]}", `{"a": [1]}`},
+		{`{a /* c */ : 1}`, `{"a": 1}`},
+	}
+	for _, tt := range tests {
+		out, _, err := normalize([]byte(tt.in))
+		if err != nil {
+			t.Errorf("normalize(%q): %v", tt.in, err)
+			continue
+		}
+		var got, want interface{}
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Errorf("normalize(%q) = %q, not JSON: %v", tt.in, out, err)
+			continue
+		}
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("normalize(%q) = %s, want %s", tt.in, out, tt.want)
+		}
+	}
+}
+
+func TestNormalizeErrors(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{`{a: foo}`, "line 1, column 5: invalid value foo, only object keys may be left unquoted"},
+		{"{\n  a: True}", "line 2, column 6: invalid value True, did you mean true?"},
+		{`[undefined]`, "line 1, column 2: invalid value undefined, only object keys may be left unquoted"},
+		{`[NaN]`, "line 1, column 2: NaN cannot be represented in JSON"},
+		{`{a: 1} /* open`, "line 1, column 8: unterminated comment"},
+		{"{\"a\": \"x\ny\"}", "line 1, column 9: newline in string"},
+		{"[1]\n / 2", "line 2, column 2: invalid character '/'"},
+	}
+	for _, tt := range tests {
+		_, _, err := normalize([]byte(tt.in))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("normalize(%q): got error %v, want %q", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeSamplesLocatesLenientErrors(t *testing.T) {
+	src := "{\n  id: 1,\n  name: 'abc',\n  tags: [1 2],\n}"
+	err := decodeSamples([]byte(src), true, func(interface{}) error { return nil })
+	want := "line 4, column 12: invalid character '2' after array element"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+}
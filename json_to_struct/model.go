@@ -0,0 +1,162 @@
+package main
+
+// The model records every value observed at a given JSON path, so that
+// several samples of the same document can be merged into one set of types.
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type kind uint8
+
+const (
+	kindNull kind = iota
+	kindBool
+	kindNumber
+	kindString
+	kindObject
+	kindArray
+	kindMixed // more than one non-null kind was observed
+)
+
+// node describes the values found at one path. Array items share a single
+// node whose path ends with "/*".
+type node struct {
+	path    string
+	counts  [kindMixed]int
+	example interface{} // first non-null value
+	fields  map[string]*node
+	elem    *node
+
+	float bool // a number with a fraction or exponent was observed
+	// Integers observed that were negative, or did not fit in each type.
+	negative, nonInt32, nonInt64, nonUint64 bool
+}
+
+func newNode(path string) *node {
+	return &node{path: path}
+}
+
+// add merges one decoded JSON value into the node.
+func (n *node) add(v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		n.counts[kindNull]++
+		return
+	case bool:
+		n.counts[kindBool]++
+	case json.Number:
+		n.counts[kindNumber]++
+		n.addNumber(string(t))
+	case string:
+		n.counts[kindString]++
+	case map[string]interface{}:
+		n.counts[kindObject]++
+		if n.fields == nil {
+			n.fields = make(map[string]*node)
+		}
+		for k, fv := range t {
+			f, ok := n.fields[k]
+			if !ok {
+				f = newNode(n.path + "/" + escapePointer(k))
+				n.fields[k] = f
+			}
+			f.add(fv)
+		}
+	case []interface{}:
+		n.counts[kindArray]++
+		if n.elem == nil {
+			n.elem = newNode(n.path + "/*")
+		}
+		for _, item := range t {
+			n.elem.add(item)
+		}
+	}
+	if n.example == nil {
+		n.example = v
+	}
+}
+
+// addNumber records the type a number literal needs: its text, not its value
+// as a float64, tells integers from decimals.
+func (n *node) addNumber(lit string) {
+	if strings.ContainsAny(lit, ".eE") {
+		n.float = true
+		return
+	}
+	if strings.HasPrefix(lit, "-") {
+		n.negative = true
+	}
+	if _, err := strconv.ParseInt(lit, 10, 32); err != nil {
+		n.nonInt32 = true
+	}
+	if _, err := strconv.ParseInt(lit, 10, 64); err != nil {
+		n.nonInt64 = true
+	}
+	if _, err := strconv.ParseUint(lit, 10, 64); err != nil {
+		n.nonUint64 = true
+	}
+}
+
+// numberType returns the Go type that holds every number observed: int when
+// they fit in 32 bits (the smallest int), then int64 or uint64. Integers too
+// large for both are kept as json.Number.
+func (n *node) numberType() string {
+	switch {
+	case n.float:
+		return "float64"
+	case !n.nonInt32:
+		return "int"
+	case !n.nonInt64:
+		return "int64"
+	case !n.nonUint64:
+		return "uint64"
+	}
+	return "json.Number"
+}
+
+// samples returns the number of values observed, nulls included.
+func (n *node) samples() int {
+	total := 0
+	for _, c := range n.counts {
+		total += c
+	}
+	return total
+}
+
+// kind returns the single non-null kind observed, kindNull if only nulls
+// (or nothing) were seen, and kindMixed otherwise.
+func (n *node) kind() kind {
+	k := kindNull
+	for i, c := range n.counts {
+		if c == 0 || kind(i) == kindNull {
+			continue
+		}
+		if k != kindNull {
+			return kindMixed
+		}
+		k = kind(i)
+	}
+	return k
+}
+
+func describeKind(k kind) string {
+	return [...]string{"null", "bool", "number", "string", "object", "array", "mixed"}[k]
+}
+
+func (n *node) sortedKeys() []string {
+	keys := make([]string, 0, len(n.fields))
+	for k := range n.fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes a key for use in a JSON Pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
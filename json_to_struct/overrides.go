@@ -0,0 +1,151 @@
+package main
+
+// Overrides are read from the -config file: a JSON object mapping paths to
+// the changes to apply to the generated code, e.g.
+//
+//	{
+//	  "":              {"typeName": "Order"},
+//	  "/price":        {"type": "decimal.Decimal", "import": "github.com/shopspring/decimal"},
+//	  "/details":      {"typeName": "OrderDetail"},
+//	  "/items/*/sku":  {"name": "SKU", "tags": {"db": "sku"}},
+//	  "/internal":     {"skip": true}
+//	}
+//
+// Paths are JSON Pointers relative to the selected value, "*" matches any
+// single key or the items of an array. When several paths match, the one with
+// the fewest wildcards wins.
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+type override struct {
+	Type     string            `json:"type,omitempty"`     // Go type used as is
+	Import   string            `json:"import,omitempty"`   // import path Type needs
+	Name     string            `json:"name,omitempty"`     // field name
+	TypeName string            `json:"typeName,omitempty"` // name of the generated type
+	Skip     bool              `json:"skip,omitempty"`     // leave the field out
+	Tags     map[string]string `json:"tags,omitempty"`     // extra struct tags
+}
+
+type overrides struct {
+	rules map[string]override
+	used  map[string]bool
+}
+
+func loadOverrides(file string) (*overrides, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	o, err := parseOverrides(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return o, nil
+}
+
+func parseOverrides(data []byte) (*overrides, error) {
+	o := &overrides{used: make(map[string]bool)}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&o.rules); err != nil {
+		return nil, err
+	}
+	for p := range o.rules {
+		if p != "" && p[0] != '/' {
+			return nil, fmt.Errorf("path %q must be a JSON Pointer", p)
+		}
+	}
+	return o, nil
+}
+
+// lookup returns the override for the node at path. A nil *overrides has no
+// rules.
+func (o *overrides) lookup(path string) override {
+	if o == nil {
+		return override{}
+	}
+	best, wildcards := "", -1
+	for p := range o.rules {
+		if n, ok := matchPath(p, path); ok && (wildcards < 0 || n < wildcards || n == wildcards && p < best) {
+			best, wildcards = p, n
+		}
+	}
+	if wildcards < 0 {
+		return override{}
+	}
+	o.used[best] = true
+	return o.rules[best]
+}
+
+// unused returns the paths that did not match any node, sorted.
+func (o *overrides) unused() []string {
+	if o == nil {
+		return nil
+	}
+	var paths []string
+	for p := range o.rules {
+		if !o.used[p] {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// matchPath reports whether pattern matches path, and with how many
+// wildcards.
+func matchPath(pattern, path string) (int, bool) {
+	ps, ns := strings.Split(pattern, "/"), strings.Split(path, "/")
+	if len(ps) != len(ns) {
+		return 0, false
+	}
+	wildcards := 0
+	for i := range ps {
+		switch {
+		case ps[i] == "*":
+			wildcards++
+		case ps[i] != ns[i]:
+			return 0, false
+		}
+	}
+	return wildcards, true
+}
+
+// jsonTag returns the value of the json struct tag for a field named key in
+// the JSON input.
+func (r override) jsonTag(key string) string {
+	if t, ok := r.Tags["json"]; ok {
+		return t
+	}
+	return key + ",omitempty"
+}
+
+// tag returns the struct tag for a field named key in the JSON input.
+func (r override) tag(key string) string {
+	tags := map[string]string{"json": r.jsonTag(key)}
+	for k, v := range r.Tags {
+		if k != "json" {
+			tags[k] = v
+		}
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if k != "json" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	s := fmt.Sprintf("json:%q", tags["json"])
+	for _, k := range keys {
+		s += fmt.Sprintf(" %s:%q", k, tags[k])
+	}
+	return s
+}
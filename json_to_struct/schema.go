@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// jsonSchema returns a JSON Schema (draft 2020-12) describing root.
+func jsonSchema(title string, root *node) ([]byte, error) {
+	s := schemaFor(root)
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = title
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func schemaFor(n *node) map[string]interface{} {
+	s := map[string]interface{}{}
+	var types []string
+	for k, c := range n.counts {
+		if c == 0 {
+			continue
+		}
+		switch kind(k) {
+		case kindNumber:
+			if n.float {
+				types = append(types, "number")
+			} else {
+				types = append(types, "integer")
+			}
+		default:
+			types = append(types, describeKind(kind(k)))
+		}
+	}
+	switch len(types) {
+	case 0:
+	case 1:
+		s["type"] = types[0]
+	default:
+		sort.Strings(types)
+		s["type"] = types
+	}
+	switch n.example.(type) {
+	case nil, map[string]interface{}, []interface{}:
+	default:
+		s["examples"] = []interface{}{n.example}
+	}
+
+	if n.counts[kindObject] > 0 {
+		props := map[string]interface{}{}
+		var required []string
+		for _, k := range n.sortedKeys() {
+			f := n.fields[k]
+			props[k] = schemaFor(f)
+			if f.samples() == n.counts[kindObject] {
+				required = append(required, k)
+			}
+		}
+		s["properties"] = props
+		if len(required) > 0 {
+			s["required"] = required
+		}
+	}
+	if n.elem != nil && n.elem.samples() > 0 {
+		s["items"] = schemaFor(n.elem)
+	}
+	return s
+}
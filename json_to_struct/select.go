@@ -0,0 +1,115 @@
+package main
+
+// Subtree selection: -path picks the value types are generated for, given as
+// a JSON Pointer (RFC 6901), e.g. /data/items/0, or as a simple path
+// expression, e.g. data.items[0] or data.items.0.
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parsePath splits expr into reference tokens.
+func parsePath(expr string) ([]string, error) {
+	if strings.HasPrefix(expr, "#") { // URI fragment representation
+		s, err := url.PathUnescape(expr[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %v", expr, err)
+		}
+		expr = s
+		if expr != "" && expr[0] != '/' {
+			return nil, fmt.Errorf("invalid path %q: JSON Pointer must start with '/'", expr)
+		}
+	}
+	if expr == "" || expr == "$" {
+		return nil, nil
+	}
+	if expr[0] == '/' {
+		return parsePointer(expr)
+	}
+	return parseDotted(expr)
+}
+
+func parsePointer(expr string) ([]string, error) {
+	var tokens []string
+	for _, tok := range strings.Split(expr[1:], "/") {
+		for i := 0; i < len(tok); i++ {
+			if tok[i] == '~' && (i+1 == len(tok) || (tok[i+1] != '0' && tok[i+1] != '1')) {
+				return nil, fmt.Errorf("invalid path %q: '~' must be followed by 0 or 1", expr)
+			}
+		}
+		tokens = append(tokens, strings.NewReplacer("~1", "/", "~0", "~").Replace(tok))
+	}
+	return tokens, nil
+}
+
+// parseDotted accepts keys separated by dots, each optionally followed by
+// array indexes in brackets.
+func parseDotted(expr string) ([]string, error) {
+	var tokens []string
+	for _, part := range strings.Split(strings.TrimPrefix(expr, "$."), ".") {
+		key, indexes := part, ""
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key, indexes = part[:i], part[i:]
+		}
+		if key != "" {
+			tokens = append(tokens, key)
+		} else if indexes == "" {
+			return nil, fmt.Errorf("invalid path %q: empty key", expr)
+		}
+		for indexes != "" {
+			end := strings.IndexByte(indexes, ']')
+			if indexes[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("invalid path %q: malformed index in %q", expr, part)
+			}
+			tokens = append(tokens, indexes[1:end])
+			indexes = indexes[end+1:]
+		}
+	}
+	return tokens, nil
+}
+
+// selectPath returns the value of v designated by tokens.
+func selectPath(v interface{}, tokens []string) (interface{}, error) {
+	for i, tok := range tokens {
+		at := pointer(tokens[:i])
+		switch t := v.(type) {
+		case map[string]interface{}:
+			child, ok := t[tok]
+			if !ok {
+				return nil, fmt.Errorf("%s has no member %q", location(at), tok)
+			}
+			v = child
+		case []interface{}:
+			i, err := strconv.Atoi(tok)
+			if err != nil || i < 0 || tok != strconv.Itoa(i) {
+				return nil, fmt.Errorf("%s is an array, %q is not an index", location(at), tok)
+			}
+			if i >= len(t) {
+				return nil, fmt.Errorf("%s has %d items, no index %d", location(at), len(t), i)
+			}
+			v = t[i]
+		default:
+			return nil, fmt.Errorf("%s is not an object or array", location(at))
+		}
+	}
+	return v, nil
+}
+
+// pointer joins reference tokens into a JSON Pointer.
+func pointer(tokens []string) string {
+	var b strings.Builder
+	for _, tok := range tokens {
+		b.WriteString("/" + escapePointer(tok))
+	}
+	return b.String()
+}
+
+func location(pointer string) string {
+	if pointer == "" {
+		return "the document"
+	}
+	return pointer
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		{"", nil},
+		{"$", nil},
+		{"/data/items/0", []string{"data", "items", "0"}},
+		{"/a~1b/c~0d", []string{"a/b", "c~d"}},
+		{"/", []string{""}},
+		{"#/a%20b/0", []string{"a b", "0"}},
+		{"#", nil},
+		{"data.items[0]", []string{"data", "items", "0"}},
+		{"data.items.0", []string{"data", "items", "0"}},
+		{"$.data[1][2].x", []string{"data", "1", "2", "x"}},
+		{"[0].id", []string{"0", "id"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.expr)
+		if err != nil {
+			t.Errorf("parsePath(%q): %v", tt.expr, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePath(%q) = %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"/a~2", "/a~", "#a", "#/%zz", "a..b", "a[0", "a[0]x"} {
+		if got, err := parsePath(expr); err == nil {
+			t.Errorf("parsePath(%q) = %q, want an error", expr, got)
+		}
+	}
+}
+
+func TestSelectPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"data": {"items": [{"id": 1}, {"id": 2}], "a/b": true}}`), &doc)
+
+	tests := []struct {
+		expr string
+		want string // JSON of the selected value, or the error
+	}{
+		{"", `{"data":{"a/b":true,"items":[{"id":1},{"id":2}]}}`},
+		{"/data/items/1", `{"id":2}`},
+		{"data.items[0].id", `1`},
+		{"/data/a~1b", `true`},
+		{"/data/missing", `/data has no member "missing"`},
+		{"/data/items/2", `/data/items has 2 items, no index 2`},
+		{"/data/items/01", `/data/items is an array, "01" is not an index`},
+		{"/data/items/-1", `/data/items is an array, "-1" is not an index`},
+		{"/data/a~1b/x", `/data/a~1b is not an object or array`},
+		{"/x", `the document has no member "x"`},
+	}
+	for _, tt := range tests {
+		tokens, err := parsePath(tt.expr)
+		if err != nil {
+			t.Fatalf("parsePath(%q): %v", tt.expr, err)
+		}
+		var got string
+		if v, err := selectPath(doc, tokens); err != nil {
+			got = err.Error()
+		} else {
+			b, _ := json.Marshal(v)
+			got = string(b)
+		}
+		if got != tt.want {
+			t.Errorf("select %q: got %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+// Serve mode exposes the generator over HTTP, as a local web server or over
+// FastCGI on standard input like fcgi/fcgi.go:
+//
+//	POST /generate?format=go|schema&name=MyStruct&lenient=1&path=/data&unwrap=1&codec=1
+//
+// The body holds the samples. The response is Go source, a JSON Schema, or
+// an error as JSON: {"error": "...", "line": 3, "column": 7}.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/fcgi"
+)
+
+const maxSampleSize = 10 << 20
+
+func serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveForm)
+	mux.HandleFunc("/generate", serveGenerate)
+	if addr != "" { // Run as a local web server
+		return http.ListenAndServe(addr, mux)
+	}
+	return fcgi.Serve(nil, mux) // Run as FCGI via standard I/O
+}
+
+func serveGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST with the samples as the body"))
+		return
+	}
+	q := r.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		name = "MyStruct"
+	}
+	tokens, err := parsePath(q.Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSampleSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxSampleSize {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("samples larger than %d bytes", maxSampleSize))
+		return
+	}
+
+	root := newNode("")
+	opts := options{queryBool(q.Get("lenient")), tokens, queryBool(q.Get("unwrap"))}
+	if err := readSamples(body, opts, root); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if root.samples() == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no samples in the body"))
+		return
+	}
+
+	switch q.Get("format") {
+	case "", "go":
+		out := generate(exportedName(name), root, genConfig{codec: queryBool(q.Get("codec")), runtime: true})
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(out.src)
+	case "schema":
+		src, err := jsonSchema(name, root)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Write(src)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q, want go or schema", q.Get("format")))
+	}
+}
+
+func queryBool(s string) bool {
+	return s == "1" || s == "true" || s == "on"
+}
+
+// writeError reports err as JSON, with its position for syntax errors.
+func writeError(w http.ResponseWriter, status int, err error) {
+	resp := struct {
+		Error  string `json:"error"`
+		Line   int    `json:"line,omitempty"`
+		Column int    `json:"column,omitempty"`
+	}{Error: err.Error()}
+	if se, ok := err.(*syntaxError); ok {
+		resp.Error, resp.Line, resp.Column = se.msg, se.line, se.col
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func serveForm(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, formPage)
+}
+
+const formPage = `<!DOCTYPE html>
+<html><head><title>json_to_struct</title></head><body>
+<form id="f">
+<p><textarea name="body" rows="20" cols="100" placeholder='{"id": 1, "name": "abc"}'></textarea></p>
+<p>
+Type name <input name="name" value="MyStruct">
+Path <input name="path" placeholder="/data/items">
+<label><input type="checkbox" name="unwrap"> unwrap</label>
+<label><input type="checkbox" name="lenient"> lenient</label>
+<label><input type="checkbox" name="codec"> codec</label>
+<select name="format"><option value="go">Go</option><option value="schema">JSON Schema</option></select>
+<button>Generate</button>
+</p>
+</form>
+<pre id="out"></pre>
+<script>
+document.getElementById("f").onsubmit = function(e) {
+  e.preventDefault();
+  var q = new URLSearchParams();
+  for (var el of this.elements) {
+    if (!el.name || el.name == "body") continue;
+    if (el.type == "checkbox") { if (el.checked) q.set(el.name, "1"); continue; }
+    q.set(el.name, el.value);
+  }
+  fetch("generate?" + q, {method: "POST", body: this.elements.body.value})
+    .then(function(r) { return r.text(); })
+    .then(function(t) { document.getElementById("out").textContent = t; });
+};
+</script>
+</body></html>
+`